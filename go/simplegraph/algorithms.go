@@ -0,0 +1,287 @@
+package simplegraph
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// adjacency loads every node id, in insertion order, and the targets of each
+// node's outgoing edges, so the algorithms below run in memory rather than
+// querying node by node
+func (g *Graph) adjacency() ([]string, map[string][]string, error) {
+	identifiers, err := g.queryStrings(SearchAllNodeIds)
+	if err != nil {
+		return nil, nil, err
+	}
+	outgoing := map[string][]string{}
+	err = g.eachEdge(func(edge EdgeData) error {
+		outgoing[edge.Source] = append(outgoing[edge.Source], edge.Target)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return identifiers, outgoing, nil
+}
+
+const (
+	DEFAULT_DAMPING    = 0.85
+	DEFAULT_ITERATIONS = 20
+)
+
+const (
+	unvisited = iota
+	visiting
+	finished
+)
+
+func findCycle(identifiers []string, outgoing map[string][]string) []string {
+	state := map[string]int{}
+	path := []string{}
+	var visit func(identifier string) []string
+	visit = func(identifier string) []string {
+		state[identifier] = visiting
+		path = append(path, identifier)
+		for _, target := range outgoing[identifier] {
+			switch state[target] {
+			case visiting:
+				// a back edge, so the cycle is the path from target onwards
+				for i := len(path) - 1; i >= 0; i-- {
+					if path[i] == target {
+						return append([]string{}, path[i:]...)
+					}
+				}
+			case unvisited:
+				cycle := visit(target)
+				if cycle != nil {
+					return cycle
+				}
+			}
+		}
+		state[identifier] = finished
+		path = path[:len(path)-1]
+		return nil
+	}
+
+	for _, identifier := range identifiers {
+		if state[identifier] == unvisited {
+			cycle := visit(identifier)
+			if cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return []string{}
+}
+
+// FindCycle returns the ids along one directed cycle, in edge order, or an
+// empty slice when the graph is acyclic
+func (g *Graph) FindCycle() ([]string, error) {
+	identifiers, outgoing, err := g.adjacency()
+	if err != nil {
+		return nil, err
+	}
+	return findCycle(identifiers, outgoing), nil
+}
+
+func FindCycle(database ...string) ([]string, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return nil, err
+	}
+	defer graph.Close()
+	return graph.FindCycle()
+}
+
+func (g *Graph) HasCycle() (bool, error) {
+	cycle, err := g.FindCycle()
+	if err != nil {
+		return false, err
+	}
+	return len(cycle) > 0, nil
+}
+
+func HasCycle(database ...string) (bool, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return false, err
+	}
+	defer graph.Close()
+	return graph.HasCycle()
+}
+
+// TopologicalSort orders the ids so every edge points from an earlier node
+// to a later one, using Kahn's algorithm
+func (g *Graph) TopologicalSort() ([]string, error) {
+	identifiers, outgoing, err := g.adjacency()
+	if err != nil {
+		return nil, err
+	}
+
+	inDegree := map[string]int{}
+	for _, targets := range outgoing {
+		for _, target := range targets {
+			inDegree[target]++
+		}
+	}
+	queue := []string{}
+	for _, identifier := range identifiers {
+		if inDegree[identifier] == 0 {
+			queue = append(queue, identifier)
+		}
+	}
+
+	order := []string{}
+	for len(queue) > 0 {
+		identifier := queue[0]
+		queue = queue[1:]
+		order = append(order, identifier)
+		for _, target := range outgoing[identifier] {
+			inDegree[target]--
+			if inDegree[target] == 0 {
+				queue = append(queue, target)
+			}
+		}
+	}
+
+	if len(order) < len(identifiers) {
+		cycle := findCycle(identifiers, outgoing)
+		if len(cycle) == 0 {
+			// only possible when foreign keys are off and an edge starts at a missing node
+			return nil, errors.New("graph has edges from nodes that do not exist")
+		}
+		return nil, fmt.Errorf("graph is not acyclic: %s -> %s", strings.Join(cycle, " -> "), cycle[0])
+	}
+	return order, nil
+}
+
+func TopologicalSort(database ...string) ([]string, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return nil, err
+	}
+	defer graph.Close()
+	return graph.TopologicalSort()
+}
+
+// ConnectedComponents groups the ids into weakly connected components,
+// ignoring edge direction; components and their members keep node order
+func (g *Graph) ConnectedComponents() ([][]string, error) {
+	identifiers, outgoing, err := g.adjacency()
+	if err != nil {
+		return nil, err
+	}
+
+	parent := map[string]string{}
+	for _, identifier := range identifiers {
+		parent[identifier] = identifier
+	}
+	var find func(identifier string) string
+	find = func(identifier string) string {
+		if parent[identifier] != identifier {
+			parent[identifier] = find(parent[identifier])
+		}
+		return parent[identifier]
+	}
+	for source, targets := range outgoing {
+		for _, target := range targets {
+			if _, found := parent[source]; !found {
+				continue
+			}
+			if _, found := parent[target]; !found {
+				continue
+			}
+			parent[find(source)] = find(target)
+		}
+	}
+
+	components := [][]string{}
+	index := map[string]int{}
+	for _, identifier := range identifiers {
+		root := find(identifier)
+		i, found := index[root]
+		if !found {
+			i = len(components)
+			index[root] = i
+			components = append(components, []string{})
+		}
+		components[i] = append(components[i], identifier)
+	}
+	return components, nil
+}
+
+func ConnectedComponents(database ...string) ([][]string, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return nil, err
+	}
+	defer graph.Close()
+	return graph.ConnectedComponents()
+}
+
+// PageRank scores every node by power iteration; a zero damping or
+// iterations falls back to DEFAULT_DAMPING and DEFAULT_ITERATIONS, and the
+// rank of nodes without outgoing edges is spread evenly over all nodes
+func (g *Graph) PageRank(damping float64, iterations int) (map[string]float64, error) {
+	if damping <= 0 {
+		damping = DEFAULT_DAMPING
+	}
+	if iterations <= 0 {
+		iterations = DEFAULT_ITERATIONS
+	}
+	identifiers, outgoing, err := g.adjacency()
+	if err != nil {
+		return nil, err
+	}
+	if g.undirected {
+		// rank flows both ways along an undirected edge
+		both := map[string][]string{}
+		for _, source := range identifiers {
+			for _, target := range outgoing[source] {
+				both[source] = append(both[source], target)
+				if target != source {
+					both[target] = append(both[target], source)
+				}
+			}
+		}
+		outgoing = both
+	}
+
+	ranks := map[string]float64{}
+	n := float64(len(identifiers))
+	for _, identifier := range identifiers {
+		ranks[identifier] = 1 / n
+	}
+	for i := 0; i < iterations; i++ {
+		dangling := 0.0
+		for _, identifier := range identifiers {
+			if len(outgoing[identifier]) == 0 {
+				dangling += ranks[identifier]
+			}
+		}
+		next := map[string]float64{}
+		for _, identifier := range identifiers {
+			next[identifier] = (1-damping)/n + damping*dangling/n
+		}
+		for _, source := range identifiers {
+			targets := outgoing[source]
+			for _, target := range targets {
+				if _, found := next[target]; found {
+					next[target] += damping * ranks[source] / float64(len(targets))
+				}
+			}
+		}
+		ranks = next
+	}
+	return ranks, nil
+}
+
+func PageRank(damping float64, iterations int, database ...string) (map[string]float64, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return nil, err
+	}
+	defer graph.Close()
+	return graph.PageRank(damping, iterations)
+}
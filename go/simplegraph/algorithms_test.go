@@ -0,0 +1,136 @@
+package simplegraph
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"testing"
+)
+
+func initializeDAG(file string) {
+	Initialize(file)
+	AddNodes([]string{"1", "2", "3", "4", "5"},
+		[][]byte{[]byte(apple), []byte(woz), []byte(jobs), []byte(wayne), []byte(markkula)}, file)
+	BulkConnectNodes([]string{"2", "3", "4", "5", "5"}, []string{"1", "1", "1", "3", "4"}, file)
+}
+
+func TestFindCycle(t *testing.T) {
+	file := "testdb.sqlite3"
+	initializeDAG(file)
+	defer os.Remove(file)
+
+	cycle, err := FindCycle(file)
+	if cycle == nil || len(cycle) != 0 || err != nil {
+		t.Errorf("FindCycle() produced %v,%v but expected [],nil", cycle, err)
+	}
+	cyclic, err := HasCycle(file)
+	if cyclic || err != nil {
+		t.Errorf("HasCycle() produced %v,%v but expected false,nil", cyclic, err)
+	}
+
+	ConnectNodes("1", "5", file)
+	cycle, err = FindCycle(file)
+	expected := []string{"1", "5", "3"}
+	if fmt.Sprint(cycle) != fmt.Sprint(expected) || err != nil {
+		t.Errorf("FindCycle() produced %v,%v but expected %v,nil", cycle, err, expected)
+	}
+	cyclic, err = HasCycle(file)
+	if !cyclic || err != nil {
+		t.Errorf("HasCycle() produced %v,%v but expected true,nil", cyclic, err)
+	}
+}
+
+func TestFindCycleSelfLoop(t *testing.T) {
+	file := "testdb.sqlite3"
+	initializeDAG(file)
+	defer os.Remove(file)
+
+	ConnectNodes("4", "4", file)
+	cycle, err := FindCycle(file)
+	if fmt.Sprint(cycle) != "[4]" || err != nil {
+		t.Errorf("FindCycle() produced %v,%v but expected [4],nil", cycle, err)
+	}
+}
+
+func TestTopologicalSort(t *testing.T) {
+	file := "testdb.sqlite3"
+	initializeDAG(file)
+	defer os.Remove(file)
+
+	order, err := TopologicalSort(file)
+	if len(order) != 5 || err != nil {
+		t.Fatalf("TopologicalSort() produced %v,%v but expected 5 ids,nil", order, err)
+	}
+	position := map[string]int{}
+	for i, identifier := range order {
+		position[identifier] = i
+	}
+	edges, _ := ListEdges(0, 0, file)
+	for _, edge := range edges {
+		if position[edge.Source] >= position[edge.Target] {
+			t.Errorf("TopologicalSort() produced %v, which puts %q after %q", order, edge.Source, edge.Target)
+		}
+	}
+
+	ConnectNodes("1", "5", file)
+	order, err = TopologicalSort(file)
+	expected := "graph is not acyclic: 1 -> 5 -> 3 -> 1"
+	if order != nil || !ErrorMatches(err, expected) {
+		t.Errorf("TopologicalSort() produced %v,%v but expected nil,%q", order, err, expected)
+	}
+}
+
+func TestConnectedComponents(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	components, err := ConnectedComponents(file)
+	if components == nil || len(components) != 0 || err != nil {
+		t.Errorf("ConnectedComponents() produced %v,%v but expected [],nil", components, err)
+	}
+
+	AddNodes([]string{"1", "2", "3", "4", "5", "6"},
+		[][]byte{[]byte(apple), []byte(woz), []byte(jobs), []byte(wayne), []byte(markkula), []byte(`{}`)}, file)
+	BulkConnectNodes([]string{"2", "3", "5"}, []string{"1", "1", "4"}, file)
+
+	components, err = ConnectedComponents(file)
+	expected := "[[1 2 3] [4 5] [6]]"
+	if fmt.Sprint(components) != expected || err != nil {
+		t.Errorf("ConnectedComponents() produced %v,%v but expected %s,nil", components, err, expected)
+	}
+}
+
+func TestPageRank(t *testing.T) {
+	file := "testdb.sqlite3"
+	initializeDAG(file)
+	defer os.Remove(file)
+
+	ranks, err := PageRank(0, 0, file)
+	if len(ranks) != 5 || err != nil {
+		t.Fatalf("PageRank() produced %v,%v but expected 5 scores,nil", ranks, err)
+	}
+	total := 0.0
+	for _, rank := range ranks {
+		total += rank
+	}
+	if math.Abs(total-1) > 1e-9 {
+		t.Errorf("PageRank() scores summed to %f but expected 1", total)
+	}
+	// everything flows into 1, and nothing flows into 5
+	for _, identifier := range []string{"2", "3", "4", "5"} {
+		if ranks["1"] <= ranks[identifier] {
+			t.Errorf("PageRank() scored 1 at %f, no higher than %s at %f", ranks["1"], identifier, ranks[identifier])
+		}
+	}
+	if ranks["5"] >= ranks["3"] || ranks["2"] != ranks["5"] {
+		t.Errorf("PageRank() produced unexpected scores %v", ranks)
+	}
+
+	Initialize("testempty.sqlite3")
+	defer os.Remove("testempty.sqlite3")
+	ranks, err = PageRank(0.85, 10, "testempty.sqlite3")
+	if len(ranks) != 0 || err != nil {
+		t.Errorf("PageRank() produced %v,%v but expected an empty map,nil", ranks, err)
+	}
+}
@@ -0,0 +1,102 @@
+package simplegraph
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// BACKUP_PAGES_PER_STEP bounds how much is copied while the source is
+// locked, so writers can get in between steps
+const BACKUP_PAGES_PER_STEP = 256
+
+func sqliteConn(raw interface{}) (*sqlite3.SQLiteConn, error) {
+	if pooled, ok := raw.(*pooledConn); ok {
+		return pooled.SQLiteConn, nil
+	}
+	conn, ok := raw.(*sqlite3.SQLiteConn)
+	if !ok {
+		return nil, errors.New("connection is not a SQLite connection")
+	}
+	return conn, nil
+}
+
+// Backup copies the live database into a standalone file at destPath with
+// SQLite's online backup API, overwriting whatever database was there; while
+// another connection keeps the source locked it waits as WithRetry says, and
+// then gives up with SQLite's busy error
+func (g *Graph) Backup(destPath string) error {
+	dest, err := NewGraph(destPath)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+
+	ctx := context.Background()
+	srcConn, err := g.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+	destConn, err := dest.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destConn.Close()
+
+	return destConn.Raw(func(destRaw interface{}) error {
+		return srcConn.Raw(func(srcRaw interface{}) error {
+			destSQLite, err := sqliteConn(destRaw)
+			if err != nil {
+				return err
+			}
+			srcSQLite, err := sqliteConn(srcRaw)
+			if err != nil {
+				return err
+			}
+			backup, err := destSQLite.Backup("main", srcSQLite, "main")
+			if err != nil {
+				return err
+			}
+			// a step that copies nothing found the source busy or locked, so
+			// wait as retry would before trying again, and give up as it would
+			backoff := g.backoff
+			stalls := 0
+			copied := 0
+			for {
+				done, err := backup.Step(BACKUP_PAGES_PER_STEP)
+				if err != nil {
+					backup.Finish()
+					return err
+				}
+				if done {
+					return backup.Finish()
+				}
+				if progress := backup.PageCount() - backup.Remaining(); progress != copied {
+					copied = progress
+					backoff = g.backoff
+					stalls = 0
+					continue
+				}
+				if stalls >= g.retries {
+					backup.Finish()
+					return sqlite3.Error{Code: sqlite3.ErrBusy}
+				}
+				stalls++
+				time.Sleep(backoff)
+				backoff *= 2
+			}
+		})
+	})
+}
+
+func Backup(destPath string, database ...string) error {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return err
+	}
+	defer graph.Close()
+	return graph.Backup(destPath)
+}
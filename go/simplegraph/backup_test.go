@@ -0,0 +1,86 @@
+package simplegraph
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestBackup(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	identifiers, nodes := makeBenchmarkNodes(3000)
+	AddNodes(identifiers, nodes, file)
+	BulkConnectNodes(identifiers[1:], identifiers[:len(identifiers)-1], file)
+
+	copied := "testbackup.sqlite3"
+	defer os.Remove(copied)
+	err := Backup(copied, file)
+	if err != nil {
+		t.Fatalf("Backup() produced an error %q but expected nil", err.Error())
+	}
+
+	for name, count := range map[string]func(...string) (int64, error){"CountNodes": CountNodes, "CountEdges": CountEdges} {
+		expected, _ := count(file)
+		actual, err := count(copied)
+		if actual != expected || err != nil {
+			t.Errorf("%s() on the backup produced %d,%v but expected %d,nil", name, actual, err, expected)
+		}
+	}
+
+	node, err := FindNode("42", copied)
+	if node != `{"id":"42","name":"node 42"}` || err != nil {
+		t.Errorf("FindNode() on the backup produced %q,%v", node, err)
+	}
+}
+
+func TestBackupOfLockedDatabase(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+	AddNode("1", []byte(apple), file)
+	copied := "testbackup.sqlite3"
+	defer os.Remove(copied)
+
+	// both graphs open their connection first, which the lock would refuse
+	impatient, _ := NewGraphWithOptions([]Option{WithBusyTimeout(0), WithRetry(2, 10*time.Millisecond)}, file)
+	defer impatient.Close()
+	impatient.CountNodes()
+	patient, _ := NewGraphWithOptions([]Option{WithBusyTimeout(0), WithRetry(6, 20*time.Millisecond)}, file)
+	defer patient.Close()
+	patient.CountNodes()
+
+	// an exclusive lock keeps even readers, and so the backup, out
+	locker, _ := NewGraphWithOptions([]Option{WithBusyTimeout(0)}, file)
+	defer locker.Close()
+	ctx := context.Background()
+	conn, _ := locker.db.Conn(ctx)
+	defer conn.Close()
+	_, err := conn.ExecContext(ctx, "BEGIN EXCLUSIVE")
+	if err != nil {
+		t.Fatalf("BEGIN EXCLUSIVE produced an error %q but expected nil", err.Error())
+	}
+
+	start := time.Now()
+	err = impatient.Backup(copied)
+	if !isTransient(err) || time.Since(start) < 30*time.Millisecond || time.Since(start) > time.Second {
+		t.Errorf("Backup() produced %v after %v but expected the database to be locked after about 30ms", err, time.Since(start))
+	}
+
+	// 20 + 40 + 80 + 160ms of backoff outlasts the lock
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		conn.ExecContext(ctx, "COMMIT")
+	}()
+	err = patient.Backup(copied)
+	if err != nil {
+		t.Fatalf("Backup() once the lock was released produced %v but expected nil", err)
+	}
+	count, err := CountNodes(copied)
+	if count != 1 || err != nil {
+		t.Errorf("CountNodes() on the backup produced %d,%v but expected 1,nil", count, err)
+	}
+}
@@ -0,0 +1,84 @@
+package simplegraph
+
+import (
+	"context"
+	"database/sql/driver"
+	"sync/atomic"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// connector opens the pool's connections for NewGraphWithOptions, and keeps
+// the foreign key setting each of them should have, which SetForeignKeys
+// can change while the pool is open
+type connector struct {
+	dsn         string
+	driver      *sqlite3.SQLiteDriver
+	opened      int32
+	foreignKeys int32
+}
+
+func foreignKeysFlag(on bool) int32 {
+	if on {
+		return 1
+	}
+	return 0
+}
+
+// dsn already sets foreign keys as given, so connections start out that way
+func newConnector(dsn string, foreignKeys bool) *connector {
+	return &connector{
+		dsn:         dsn,
+		driver:      &sqlite3.SQLiteDriver{},
+		opened:      foreignKeysFlag(foreignKeys),
+		foreignKeys: foreignKeysFlag(foreignKeys),
+	}
+}
+
+func (c *connector) setForeignKeys(on bool) {
+	atomic.StoreInt32(&c.foreignKeys, foreignKeysFlag(on))
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.driver.Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	// the DSN is out of date if SetForeignKeys ran since the pool opened
+	pooled := &pooledConn{SQLiteConn: conn.(*sqlite3.SQLiteConn), connector: c, foreignKeys: c.opened}
+	err = pooled.ResetSession(ctx)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return pooled, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return c.driver
+}
+
+// pooledConn is a SQLite connection which database/sql brings up to date
+// with the connector's foreign key setting whenever it reuses it
+type pooledConn struct {
+	*sqlite3.SQLiteConn
+	connector   *connector
+	foreignKeys int32
+}
+
+func (p *pooledConn) ResetSession(ctx context.Context) error {
+	wanted := atomic.LoadInt32(&p.connector.foreignKeys)
+	if wanted == p.foreignKeys {
+		return nil
+	}
+	statement := ForeignKeysOff
+	if wanted == 1 {
+		statement = ForeignKeysOn
+	}
+	_, err := p.ExecContext(ctx, statement, nil)
+	if err != nil {
+		return driver.ErrBadConn
+	}
+	p.foreignKeys = wanted
+	return nil
+}
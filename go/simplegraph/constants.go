@@ -1,18 +1,126 @@
 package simplegraph
 
 const (
+    AnalyzeDatabase = `ANALYZE
+`
+
+    CheckpointDatabase = `PRAGMA wal_checkpoint(TRUNCATE)
+`
+
+    CountAllEdges = `SELECT count(*) FROM edges
+`
+
+    CountAllNodes = `SELECT count(*) FROM nodes
+`
+
+    CountEdgesFrom = `SELECT count(*) FROM edges WHERE source = ?
+`
+
+    CountEdgesTo = `SELECT count(*) FROM edges WHERE target = ?
+`
+
+    CountNodesByProperty = `SELECT value, count(*) FROM (SELECT json_extract(body, ?) AS value FROM nodes)
+WHERE value IS NOT NULL
+GROUP BY value
+`
+
+    CountOrphanNodes = `SELECT count(*) FROM nodes
+WHERE NOT EXISTS (SELECT 1 FROM edges WHERE source = nodes.id)
+  AND NOT EXISTS (SELECT 1 FROM edges WHERE target = nodes.id)
+`
+
+    CreateTextSearch = `CREATE VIRTUAL TABLE IF NOT EXISTS nodes_fts USING fts5(body, content = 'nodes', content_rowid = 'rowid');
+
+CREATE TRIGGER IF NOT EXISTS nodes_fts_insert AFTER INSERT ON nodes BEGIN
+  INSERT INTO nodes_fts(rowid, body) VALUES (new.rowid, new.body);
+END;
+
+CREATE TRIGGER IF NOT EXISTS nodes_fts_delete AFTER DELETE ON nodes BEGIN
+  INSERT INTO nodes_fts(nodes_fts, rowid, body) VALUES ('delete', old.rowid, old.body);
+END;
+
+CREATE TRIGGER IF NOT EXISTS nodes_fts_update AFTER UPDATE ON nodes BEGIN
+  INSERT INTO nodes_fts(nodes_fts, rowid, body) VALUES ('delete', old.rowid, old.body);
+  INSERT INTO nodes_fts(rowid, body) VALUES (new.rowid, new.body);
+END;
+`
+
+    DeferForeignKeys = `PRAGMA defer_foreign_keys = ON
+`
+
+    DeleteAllEdges = `DELETE FROM edges
+`
+
+    DeleteAllNodes = `DELETE FROM nodes
+`
+
     DeleteEdge = `DELETE FROM edges WHERE source = ? OR target = ?
 `
 
+    DeleteEdgesBetween = `DELETE FROM edges WHERE source = ? AND target = ?
+`
+
+    DeleteEdgesFromNodes = `DELETE FROM edges WHERE source IN 
+`
+
+    DeleteEdgesToNodes = `DELETE FROM edges WHERE target IN 
+`
+
+    DeleteMergedDuplicates = `WITH moved(edge, other) AS (
+  SELECT rowid, CASE WHEN source = ?1 AND target = ?1 THEN ?2 WHEN source = ?1 THEN target ELSE source END
+  FROM edges WHERE source = ?1 OR target = ?1
+)
+DELETE FROM edges WHERE rowid IN (
+  SELECT edge FROM moved WHERE EXISTS (
+    SELECT 1 FROM edges WHERE (source = ?2 AND target = moved.other) OR (source = moved.other AND target = ?2)
+  )
+)
+`
+
     DeleteNode = `DELETE FROM nodes WHERE id = ?
 `
 
+    DeleteNodesByIds = `DELETE FROM nodes WHERE id IN 
+`
+
+    DeleteOneEdgeBetween = `DELETE FROM edges WHERE rowid = (
+  SELECT rowid FROM edges WHERE source = ? AND target = ? AND properties = json(?) LIMIT 1
+)
+`
+
+    DeleteSelfLoops = `DELETE FROM edges WHERE source = target
+`
+
     InsertEdge = `INSERT INTO edges VALUES(?, ?, json(?))
 `
 
+    ExplainStatement = `EXPLAIN QUERY PLAN 
+`
+
+    ForeignKeysOff = `PRAGMA foreign_keys = OFF
+`
+
+    ForeignKeysOn = `PRAGMA foreign_keys = ON
+`
+
+    InsertEdgeIfAbsent = `INSERT INTO edges SELECT ?, ?, json(?)
+WHERE NOT EXISTS (SELECT 1 FROM edges WHERE source = ? AND target = ?)
+`
+
+    InsertNodeIfAbsent = `INSERT INTO nodes VALUES(json(?))
+ON CONFLICT(id) DO NOTHING
+`
+
     InsertNode = `INSERT INTO nodes VALUES(json(?))
 `
 
+    InsertOrUpdateNode = `INSERT INTO nodes VALUES(json_set(json(?), '$.id', ?))
+ON CONFLICT(id) DO UPDATE SET body = excluded.body
+`
+
+    RebuildTextSearch = `INSERT INTO nodes_fts(nodes_fts) VALUES ('rebuild')
+`
+
     Schema = `CREATE TABLE IF NOT EXISTS nodes (
     body TEXT,
     id   TEXT GENERATED ALWAYS AS (json_extract(body, '$.id')) VIRTUAL NOT NULL UNIQUE
@@ -32,6 +140,34 @@ CREATE INDEX IF NOT EXISTS source_idx ON edges(source);
 CREATE INDEX IF NOT EXISTS target_idx ON edges(target);
 `
 
+    SearchAllEdges = `SELECT source, target, properties FROM edges ORDER BY rowid LIMIT ? OFFSET ?
+`
+
+    SearchAllNodeIds = `SELECT id FROM nodes ORDER BY rowid
+`
+
+    SearchAllNodes = `SELECT body FROM nodes ORDER BY rowid LIMIT ? OFFSET ?
+`
+
+    SearchDistinctValues = `SELECT DISTINCT value FROM (SELECT json_extract(body, ?) AS value FROM nodes)
+WHERE value IS NOT NULL
+ORDER BY value
+`
+
+    SearchEdgeProperties = `SELECT properties FROM edges WHERE source = ? AND target = ?
+`
+
+    SearchEdgesBetweenUndirected = `SELECT source, target, properties FROM edges
+WHERE (source = ? AND target = ?) OR (source = ? AND target = ?)
+ORDER BY rowid
+`
+
+    SearchEdgesBetween = `SELECT source, target, properties FROM edges WHERE source = ? AND target = ? ORDER BY rowid
+`
+
+    SearchEdgesFromNodes = `SELECT source, target, properties FROM edges WHERE source IN 
+`
+
     SearchEdgesInbound = `SELECT * FROM edges WHERE source = ?
 `
 
@@ -43,12 +179,71 @@ UNION
 SELECT * FROM edges WHERE target = ?
 `
 
+    SearchMaxDegree = `SELECT coalesce(max(degree), 0) FROM (
+  SELECT (SELECT count(*) FROM edges WHERE source = nodes.id) + (SELECT count(*) FROM edges WHERE target = nodes.id) AS degree FROM nodes
+)
+`
+
+    SearchNeighbors = `SELECT target FROM edges WHERE source = ?
+UNION
+SELECT source FROM edges WHERE target = ?
+`
+
+    SearchNodeExists = `SELECT 1 FROM nodes WHERE id = ? LIMIT 1
+`
+
     SearchNodeById = `SELECT body FROM nodes WHERE id = ?
 `
 
+    SearchNodeIds = `SELECT id FROM nodes WHERE id IN 
+`
+
     SearchNode = `SELECT body FROM nodes WHERE 
 `
 
+    SearchNodesByIds = `SELECT id, body FROM nodes WHERE id IN 
+`
+
+    SearchNodesByProperty = `SELECT body FROM nodes WHERE json_extract(body, ?) = ?
+`
+
+    SearchNodesByText = `SELECT nodes.body FROM nodes_fts JOIN nodes ON nodes.rowid = nodes_fts.rowid WHERE nodes_fts MATCH ? ORDER BY rank
+`
+
+    SearchNodesByType = `SELECT body FROM nodes
+WHERE EXISTS (SELECT 1 FROM json_each(nodes.body, '$.type') WHERE json_each.value = ?)
+`
+
+    SearchOrphanNodes = `SELECT id FROM nodes
+WHERE NOT EXISTS (SELECT 1 FROM edges WHERE source = nodes.id)
+  AND NOT EXISTS (SELECT 1 FROM edges WHERE target = nodes.id)
+ORDER BY rowid
+`
+
+    SearchPatchedNode = `SELECT json_patch(body, json(?)) FROM nodes WHERE id = ?
+`
+
+    SearchPatchedNodes = `SELECT id, json_patch(body, json(?)) FROM nodes WHERE 
+`
+
+    SearchSchemaVersion = `PRAGMA user_version
+`
+
+    SearchSelfLoops = `SELECT source, target, properties FROM edges WHERE source = target ORDER BY rowid
+`
+
+    SearchSourcesOfNodes = `SELECT target, source FROM edges WHERE target IN 
+`
+
+    SearchTargetsOfNodes = `SELECT source, target FROM edges WHERE source IN 
+`
+
+    SearchTargets = `SELECT target FROM edges WHERE source = ?
+`
+
+    SearchTextIndex = `SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'nodes_fts'
+`
+
     TraverseInbound = `WITH RECURSIVE traverse(id) AS (
   SELECT ?
   UNION
@@ -101,7 +296,46 @@ SELECT * FROM edges WHERE target = ?
 ) SELECT x, y, obj FROM traverse;
 `
 
-    UpdateNode = `UPDATE nodes SET body = json(?) WHERE id = ?
+    UpdateEdgeLoops = `UPDATE edges SET source = ?, target = ? WHERE source = ? AND target = ?
+`
+
+    UpdateEdgeSources = `UPDATE edges SET source = ? WHERE source = ? AND target != ?
+`
+
+    UpdateEdgeTargets = `UPDATE edges SET target = ? WHERE target = ? AND source != ?
+`
+
+    UpdateEdgesBetween = `UPDATE edges SET properties = json(?) WHERE source = ? AND target = ?
+`
+
+    UpdateEdgesIntoOrder = `UPDATE edges SET source = target, target = source
+WHERE (source = ? OR target = ?) AND source > target
+`
+
+    UpdateNodeById = `UPDATE nodes SET body = json(?) WHERE id = ?
+`
+
+    UpdateNodeId = `UPDATE nodes SET body = json_set(body, '$.id', ?) WHERE id = ?
+`
+
+    UpdateNodeKeepingId = `UPDATE nodes SET body = json_set(json(?), '$.id', ?) WHERE id = ?
+`
+
+    UpdateNodeWithPatch = `UPDATE nodes SET body = json_set(json_patch(body, json(?)), '$.id', ?) WHERE id = ?
+`
+
+    UpdateNodesWithPatch = `UPDATE nodes SET body = json_set(json_patch(body, json(?)), '$.id', id) WHERE 
+`
+
+    UpdateOneEdgeBetween = `UPDATE edges SET properties = json(?) WHERE rowid = (
+  SELECT rowid FROM edges WHERE source = ? AND target = ? AND properties = json(?) LIMIT 1
+)
+`
+
+    UpdateSchemaVersion = `PRAGMA user_version = 
+`
+
+    VacuumDatabase = `VACUUM
 `
 
 )
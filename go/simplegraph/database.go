@@ -2,25 +2,74 @@ package simplegraph
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"log"
-	"path/filepath"
+	"sort"
 	"strings"
+	"unicode"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
 const (
-	SQLITE                  = "sqlite3"
-	WITH_FOREIGN_KEY_PRAGMA = "%s?_foreign_keys=true"
-	ID_CONSTRAINT           = "NOT NULL constraint failed: nodes.id"
-	UNIQUE_ID_CONSTRAINT    = "UNIQUE constraint failed: nodes.id"
-	NO_ROWS_FOUND           = "sql: no rows in result set"
+	SQLITE               = "sqlite3"
+	IN_MEMORY            = ":memory:"
+	IN_MEMORY_REFERENCE  = "file::memory:"
+	ID_CONSTRAINT        = "NOT NULL constraint failed: nodes.id"
+	UNIQUE_ID_CONSTRAINT = "UNIQUE constraint failed: nodes.id"
+	NO_ROWS_FOUND        = "sql: no rows in result set"
+	MAX_IDS_PER_QUERY    = 500
+	INVALID_NODE_JSON    = "node body is not valid JSON"
+	INVALID_EDGE_JSON    = "edge properties are not valid JSON"
+	READ_ONLY            = "graph is read-only"
+	NO_TEXT_SEARCH       = "full-text search needs SQLite compiled with FTS5 (the sqlite_fts5 build tag)"
+	TEXT_SEARCH_DISABLED = "full-text search is not enabled (see EnableTextSearch)"
+	NO_SCHEMA            = "database has no schema yet (see Initialize)"
 )
 
+// ErrNodeNotFound is what errors.Is matches when a node looked up by id
+// does not exist
+var ErrNodeNotFound = errors.New("node not found")
+
+// notFoundError names the missing node and keeps the database's own error
+// underneath for anyone who wants it
+type notFoundError struct {
+	identifier string
+	err        error
+}
+
+func (e notFoundError) Error() string {
+	return fmt.Sprintf("node %q not found: %v", e.identifier, e.err)
+}
+
+func (e notFoundError) Is(target error) bool {
+	return target == ErrNodeNotFound
+}
+
+func (e notFoundError) Unwrap() error {
+	return e.err
+}
+
+// missingEndError names the end of an edge, source or target, whose node
+// does not exist
+type missingEndError struct {
+	end        string
+	identifier string
+}
+
+func (e missingEndError) Error() string {
+	return fmt.Sprintf("%s node %q does not exist", e.end, e.identifier)
+}
+
+func (e missingEndError) Is(target error) bool {
+	return target == ErrNodeNotFound
+}
+
 type NodeData struct {
 	Identifier interface{} `json:"id"`
 	Body       interface{}
@@ -38,15 +87,7 @@ type GraphData struct {
 }
 
 func resolveDbFileReference(names ...string) (string, error) {
-	args := len(names)
-	switch args {
-	case 1:
-		return fmt.Sprintf(WITH_FOREIGN_KEY_PRAGMA, names[0]), nil
-	case 2:
-		return fmt.Sprintf(WITH_FOREIGN_KEY_PRAGMA, filepath.Join(names[0], names[1])), nil
-	default:
-		return "", errors.New("invalid database file reference")
-	}
+	return newOptions(nil).reference(names...)
 }
 
 func evaluate(err error) {
@@ -55,25 +96,19 @@ func evaluate(err error) {
 	}
 }
 
-func Initialize(database ...string) {
-	init := func(db *sql.DB) error {
-		for _, statement := range strings.Split(Schema, ";") {
-			sql := strings.TrimSpace(statement)
-			if len(sql) > 0 {
-				stmt, err := db.Prepare(sql)
-				evaluate(err)
-				stmt.Exec()
-			}
-		}
-		return nil
-	}
+// Initialize creates the schema on a new database, and upgrades one made by
+// an earlier version of this package
+func (g *Graph) Initialize() error {
+	return g.Migrate()
+}
 
-	dbReference, err := resolveDbFileReference(database...)
-	evaluate(err)
-	db, dbErr := sql.Open(SQLITE, dbReference)
-	evaluate(dbErr)
-	defer db.Close()
-	init(db)
+func Initialize(database ...string) error {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return err
+	}
+	defer graph.Close()
+	return graph.Initialize()
 }
 
 func makeBulkInsertStatement(statement string, inserts int) string {
@@ -89,343 +124,1946 @@ func makeBulkInsertStatement(statement string, inserts int) string {
 	return statement
 }
 
-func makeBulkEdgeInserts(sources []string, targets []string, properties []string) []interface{} {
+func makeBulkEdgeInserts(sources []string, targets []string, properties []string) ([]interface{}, error) {
 	l := len(sources)
-	if l != len(targets) && l != len(properties) {
-		evaluate(errors.New("unequal edge lists"))
+	if l != len(targets) || l != len(properties) {
+		return nil, errors.New("unequal edge lists")
 	}
 	args := make([]interface{}, 0, l*3)
 	for i := 0; i < l; i++ {
+		err := validateProperties([]byte(properties[i]))
+		if err != nil {
+			return nil, fmt.Errorf("edge %d: %w", i, err)
+		}
 		args = append(args, sources[i])
 		args = append(args, targets[i])
 		args = append(args, properties[i])
 	}
-	return args
+	return args, nil
 }
 
-func insertMany(nodes []interface{}, database ...string) (int64, error) {
-	ins := func(db *sql.DB) (sql.Result, error) {
-		stmt, stmtErr := db.Prepare(makeBulkInsertStatement(InsertNode, len(nodes)))
-		evaluate(stmtErr)
-		return stmt.Exec(nodes...)
+func (g *Graph) insertMany(ctx context.Context, nodes []interface{}) (int64, error) {
+	if err := g.writable(); err != nil {
+		return 0, err
+	}
+	// cache the statement first, the transaction may hold the only connection
+	_, stmtErr := g.prepare(ctx, InsertNode)
+	if stmtErr != nil {
+		return 0, stmtErr
 	}
 
-	dbReference, err := resolveDbFileReference(database...)
-	evaluate(err)
-	db, dbErr := sql.Open(SQLITE, dbReference)
-	evaluate(dbErr)
-	defer db.Close()
-	in, inErr := ins(db)
-	if inErr != nil {
-		return 0, inErr
+	var count int64
+	err := g.WithTransactionContext(ctx, func(tx *Tx) error {
+		count = 0
+		stmt, err := tx.prepare(ctx, InsertNode)
+		if err != nil {
+			return err
+		}
+		for i, node := range nodes {
+			in, inErr := stmt.ExecContext(ctx, node)
+			if inErr != nil {
+				return fmt.Errorf("node %d: %w", i, inErr)
+			}
+			rows, rowsErr := in.RowsAffected()
+			if rowsErr != nil {
+				return rowsErr
+			}
+			count += rows
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
 	}
-	return in.RowsAffected()
+	return count, nil
 }
 
-func insertOne(node string, database ...string) (int64, error) {
-	ins := func(db *sql.DB) (sql.Result, error) {
-		stmt, stmtErr := db.Prepare(InsertNode)
-		evaluate(stmtErr)
-		return stmt.Exec(node)
+func (g *Graph) connectMany(ctx context.Context, edges []interface{}, count int) (int64, error) {
+	if err := g.writable(); err != nil {
+		return 0, err
 	}
+	stmt, stmtErr := g.db.PrepareContext(ctx, makeBulkInsertStatement(InsertEdge, count))
+	if stmtErr != nil {
+		return 0, stmtErr
+	}
+	defer stmt.Close()
 
-	dbReference, err := resolveDbFileReference(database...)
-	evaluate(err)
-	db, dbErr := sql.Open(SQLITE, dbReference)
-	evaluate(dbErr)
-	defer db.Close()
-	in, inErr := ins(db)
-	if inErr != nil {
-		return 0, inErr
+	var affected int64
+	err := g.retry(ctx, func() error {
+		in, inErr := stmt.ExecContext(ctx, edges...)
+		if inErr != nil {
+			return inErr
+		}
+		var rowsErr error
+		affected, rowsErr = in.RowsAffected()
+		return rowsErr
+	})
+	if err != nil {
+		return 0, err
 	}
-	return in.RowsAffected()
+	return affected, nil
 }
 
-func connectMany(edges []interface{}, count int, database ...string) (int64, error) {
-	ins := func(db *sql.DB) (sql.Result, error) {
-		stmt, stmtErr := db.Prepare(makeBulkInsertStatement(InsertEdge, count))
-		evaluate(stmtErr)
-		return stmt.Exec(edges...)
+func validateNode(node []byte) error {
+	if !json.Valid(node) {
+		return errors.New(INVALID_NODE_JSON)
 	}
+	return nil
+}
 
-	dbReference, err := resolveDbFileReference(database...)
-	evaluate(err)
-	db, dbErr := sql.Open(SQLITE, dbReference)
-	evaluate(dbErr)
-	defer db.Close()
-	in, inErr := ins(db)
-	if inErr != nil {
-		return 0, inErr
+func validateProperties(properties []byte) error {
+	if !json.Valid(properties) {
+		return errors.New(INVALID_EDGE_JSON)
 	}
-	return in.RowsAffected()
+	return nil
 }
 
-func needsIdentifier(node []byte) bool {
+func needsIdentifier(node []byte) (bool, error) {
 	var nodeData NodeData
 	err := json.Unmarshal(node, &nodeData)
-	evaluate(err)
-	return nodeData.Identifier == nil
+	if err != nil {
+		return false, err
+	}
+	return nodeData.Identifier == nil, nil
+}
+
+func appendMember(node []byte, key string, value []byte) ([]byte, error) {
+	trimmed := bytes.TrimSpace(node)
+	if len(trimmed) < 2 || trimmed[0] != '{' || trimmed[len(trimmed)-1] != '}' {
+		return nil, errors.New("node body is not a JSON object")
+	}
+	name, err := json.Marshal(key)
+	if err != nil {
+		return nil, err
+	}
+
+	closingBraceIdx := len(trimmed) - 1
+	updated := make([]byte, 0, len(trimmed)+len(name)+len(value)+4)
+	updated = append(updated, trimmed[:closingBraceIdx]...)
+	if len(bytes.TrimSpace(trimmed[1:closingBraceIdx])) > 0 {
+		updated = append(updated, ',', ' ')
+	}
+	updated = append(updated, name...)
+	updated = append(updated, ':', ' ')
+	updated = append(updated, value...)
+	return append(updated, '}'), nil
+}
+
+// setIdentifier decodes the body rather than splicing into it, so the id is
+// placed correctly however the object is laid out; members come back sorted
+// by name, with their values untouched
+func setIdentifier(node []byte, identifier string) ([]byte, error) {
+	var members map[string]json.RawMessage
+	err := json.Unmarshal(node, &members)
+	if err != nil || members == nil {
+		return nil, errors.New("node body is not a JSON object")
+	}
+	id, err := json.Marshal(identifier)
+	if err != nil {
+		return nil, err
+	}
+	members["id"] = id
+
+	var updated bytes.Buffer
+	encoder := json.NewEncoder(&updated)
+	encoder.SetEscapeHTML(false)
+	err = encoder.Encode(members)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(updated.Bytes(), []byte("\n")), nil
+}
+
+// canonicalize re-encodes the body with its object members sorted by name at
+// every level; numbers are kept exactly as written
+func canonicalize(node []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(node))
+	decoder.UseNumber()
+	var value interface{}
+	err := decoder.Decode(&value)
+	if err != nil {
+		return nil, err
+	}
+
+	var canonical bytes.Buffer
+	encoder := json.NewEncoder(&canonical)
+	encoder.SetEscapeHTML(false)
+	err = encoder.Encode(value)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(canonical.Bytes(), []byte("\n")), nil
 }
 
-func setIdentifier(node []byte, identifier string) []byte {
-	closingBraceIdx := bytes.LastIndexByte(node, '}')
-	if closingBraceIdx > 0 {
-		addId := []byte(fmt.Sprintf(", \"id\": %q", identifier))
-		node = append(node[:closingBraceIdx], addId...)
-		node = append(node, '}')
+// canonicalNode prepares a body for update or upsert on a graph opened
+// WithCanonicalJSON; the id goes in first, since the statements would
+// otherwise append it after the sorted members
+func canonicalNode(q querier, identifier string, node []byte) ([]byte, error) {
+	if !q.canonicalJSON() {
+		return node, nil
 	}
-	return node
+	node, err := setIdentifier(node, identifier)
+	if err != nil {
+		return nil, err
+	}
+	return canonicalize(node)
+}
+
+// newNode readies a body for InsertNode, adding identifier as its id if it
+// has none
+func newNode(q querier, identifier string, node []byte) ([]byte, error) {
+	err := q.checkNode(node)
+	if err != nil {
+		return nil, err
+	}
+	missing, err := needsIdentifier(node)
+	if err != nil {
+		return nil, err
+	}
+	if missing {
+		node, err = setIdentifier(node, identifier)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if q.canonicalJSON() {
+		return canonicalize(node)
+	}
+	return node, nil
+}
+
+func insertNode(ctx context.Context, q querier, identifier string, node []byte) (int64, error) {
+	node, err := newNode(q, identifier, node)
+	if err != nil {
+		return 0, err
+	}
+	return execAffected(ctx, q, InsertNode, string(node))
+}
+
+func (g *Graph) AddNodeContext(ctx context.Context, identifier string, node []byte) (int64, error) {
+	return insertNode(ctx, g, identifier, node)
+}
+
+func (g *Graph) AddNode(identifier string, node []byte) (int64, error) {
+	return g.AddNodeContext(context.Background(), identifier, node)
+}
+
+func AddNodeContext(ctx context.Context, identifier string, node []byte, database ...string) (int64, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return 0, err
+	}
+	defer graph.Close()
+	return graph.AddNodeContext(ctx, identifier, node)
 }
 
 func AddNode(identifier string, node []byte, database ...string) (int64, error) {
-	if needsIdentifier(node) {
-		return insertOne(string(setIdentifier(node, identifier)), database...)
+	return AddNodeContext(context.Background(), identifier, node, database...)
+}
+
+// AddNodeReturningRowID is AddNode returning the rowid SQLite gave the new
+// node, which is the order ListNodes and IterateNodes go in; Vacuum may
+// renumber the rowids, so they are no good as lasting references
+func (g *Graph) AddNodeReturningRowID(identifier string, node []byte) (int64, error) {
+	node, err := newNode(g, identifier, node)
+	if err != nil {
+		return 0, err
+	}
+	result, err := execResult(context.Background(), g, InsertNode, string(node))
+	if err != nil {
+		return 0, err
 	}
-	return insertOne(string(node), database...)
+	return result.LastInsertId()
 }
 
-func AddNodes(identifiers []string, nodes [][]byte, database ...string) (int64, error) {
+func AddNodeReturningRowID(identifier string, node []byte, database ...string) (int64, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return 0, err
+	}
+	defer graph.Close()
+	return graph.AddNodeReturningRowID(identifier, node)
+}
+
+// AddNodeAndId stores the node with its id set to identifier, replacing any
+// id the body already has
+func (g *Graph) AddNodeAndId(node []byte, identifier string) (int64, error) {
+	err := g.checkNode(node)
+	if err != nil {
+		return 0, err
+	}
+	node, err = setIdentifier(node, identifier)
+	if err != nil {
+		return 0, err
+	}
+	if g.canonical {
+		node, err = canonicalize(node)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return execAffected(context.Background(), g, InsertNode, string(node))
+}
+
+func AddNodeAndId(node []byte, identifier string, database ...string) (int64, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return 0, err
+	}
+	defer graph.Close()
+	return graph.AddNodeAndId(node, identifier)
+}
+
+func (g *Graph) AddNodesContext(ctx context.Context, identifiers []string, nodes [][]byte) (int64, error) {
 	l := len(nodes)
 	if l != len(identifiers) {
-		evaluate(errors.New("unequal node, identifier lists"))
+		return 0, errors.New("unequal node, identifier lists")
 	}
 	args := make([]interface{}, l)
 	for i := 0; i < l; i++ {
-		if needsIdentifier(nodes[i]) {
-			args[i] = string(setIdentifier(nodes[i], identifiers[i]))
-		} else {
-			args[i] = string(nodes[i])
+		err := g.checkNode(nodes[i])
+		if err != nil {
+			return 0, fmt.Errorf("node %d: %w", i, err)
+		}
+		missing, err := needsIdentifier(nodes[i])
+		if err != nil {
+			return 0, fmt.Errorf("node %d: %w", i, err)
+		}
+		node := nodes[i]
+		if missing {
+			node, err = setIdentifier(node, identifiers[i])
+			if err != nil {
+				return 0, fmt.Errorf("node %d: %w", i, err)
+			}
+		}
+		if g.canonical {
+			node, err = canonicalize(node)
+			if err != nil {
+				return 0, fmt.Errorf("node %d: %w", i, err)
+			}
+		}
+		args[i] = string(node)
+
+	}
+	return g.insertMany(ctx, args)
+}
+
+func (g *Graph) AddNodes(identifiers []string, nodes [][]byte) (int64, error) {
+	return g.AddNodesContext(context.Background(), identifiers, nodes)
+}
+
+func AddNodesContext(ctx context.Context, identifiers []string, nodes [][]byte, database ...string) (int64, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return 0, err
+	}
+	defer graph.Close()
+	return graph.AddNodesContext(ctx, identifiers, nodes)
+}
+
+func AddNodes(identifiers []string, nodes [][]byte, database ...string) (int64, error) {
+	return AddNodesContext(context.Background(), identifiers, nodes, database...)
+}
+
+// defaultNode readies a body to be stored under identifier if there is no
+// node with that id yet
+func (g *Graph) defaultNode(identifier string, body []byte) ([]byte, error) {
+	err := g.checkNode(body)
+	if err != nil {
+		return nil, err
+	}
+	node, err := setIdentifier(body, identifier)
+	if err != nil {
+		return nil, err
+	}
+	if g.canonical {
+		return canonicalize(node)
+	}
+	return node, nil
+}
+
+// FindOrCreateNode returns the node's body, first adding defaultBody under
+// that id if there is no such node; the bool says whether it was added, and
+// since the insert does nothing once the id exists, concurrent callers
+// cannot both create it
+func (g *Graph) FindOrCreateNode(identifier string, defaultBody []byte) (string, bool, error) {
+	if err := g.writable(); err != nil {
+		return "", false, err
+	}
+	node, err := g.defaultNode(identifier, defaultBody)
+	if err != nil {
+		return "", false, err
+	}
+
+	var body string
+	var created bool
+	err = g.WithTransaction(func(tx *Tx) error {
+		count, err := execAffected(tx.ctx, tx, InsertNodeIfAbsent, string(node))
+		if err != nil {
+			return err
+		}
+		created = count == 1
+		body, err = findNode(tx.ctx, tx, identifier)
+		return err
+	})
+	if err != nil {
+		return "", false, err
+	}
+	return body, created, nil
+}
+
+func FindOrCreateNode(identifier string, defaultBody []byte, database ...string) (string, bool, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return "", false, err
+	}
+	defer graph.Close()
+	return graph.FindOrCreateNode(identifier, defaultBody)
+}
+
+// EnsureEdge connects source to target in one transaction, first adding
+// either node that does not exist yet with the body given for it, or {} if
+// that is nil; nodes that already exist are left as they are
+func (g *Graph) EnsureEdge(source string, sourceBody []byte, target string, targetBody []byte, properties []byte) error {
+	if err := g.writable(); err != nil {
+		return err
+	}
+	if properties == nil {
+		properties = []byte(`{}`)
+	}
+	nodes := [][]byte{}
+	for _, end := range []struct {
+		identifier string
+		body       []byte
+	}{{source, sourceBody}, {target, targetBody}} {
+		if end.body == nil {
+			end.body = []byte(`{}`)
+		}
+		node, err := g.defaultNode(end.identifier, end.body)
+		if err != nil {
+			return err
+		}
+		nodes = append(nodes, node)
+	}
+
+	return g.WithTransaction(func(tx *Tx) error {
+		for _, node := range nodes {
+			_, err := execAffected(tx.ctx, tx, InsertNodeIfAbsent, string(node))
+			if err != nil {
+				return err
+			}
+		}
+		_, err := connectNodes(tx.ctx, tx, source, target, properties)
+		return err
+	})
+}
+
+func EnsureEdge(source string, sourceBody []byte, target string, targetBody []byte, properties []byte, database ...string) error {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return err
+	}
+	defer graph.Close()
+	return graph.EnsureEdge(source, sourceBody, target, targetBody, properties)
+}
+
+// connectNodes checks both ends exist before inserting, to name the one
+// that is missing; the foreign keys still catch a node removed in between
+func connectNodes(ctx context.Context, q querier, sourceId string, targetId string, properties []byte) (int64, error) {
+	err := validateProperties(properties)
+	if err != nil {
+		return 0, err
+	}
+	if err := q.writable(); err != nil {
+		return 0, err
+	}
+	for _, end := range []missingEndError{{"source", sourceId}, {"target", targetId}} {
+		exists, err := nodeExists(ctx, q, end.identifier)
+		if err != nil {
+			return 0, err
+		}
+		if !exists {
+			return 0, end
+		}
+	}
+	if q.undirectedEdges() {
+		sourceId, targetId = edgeEnds(q, sourceId, targetId)
+		return execAffected(ctx, q, InsertEdgeIfAbsent, sourceId, targetId, string(properties), sourceId, targetId)
+	}
+	return execAffected(ctx, q, InsertEdge, sourceId, targetId, string(properties))
+}
+
+func (g *Graph) ConnectNodesWithPropertiesContext(ctx context.Context, sourceId string, targetId string, properties []byte) (int64, error) {
+	return connectNodes(ctx, g, sourceId, targetId, properties)
+}
+
+func (g *Graph) ConnectNodesWithProperties(sourceId string, targetId string, properties []byte) (int64, error) {
+	return g.ConnectNodesWithPropertiesContext(context.Background(), sourceId, targetId, properties)
+}
+
+func ConnectNodesWithPropertiesContext(ctx context.Context, sourceId string, targetId string, properties []byte, database ...string) (int64, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return 0, err
+	}
+	defer graph.Close()
+	return graph.ConnectNodesWithPropertiesContext(ctx, sourceId, targetId, properties)
+}
+
+func ConnectNodesWithProperties(sourceId string, targetId string, properties []byte, database ...string) (int64, error) {
+	return ConnectNodesWithPropertiesContext(context.Background(), sourceId, targetId, properties, database...)
+}
+
+func (g *Graph) ConnectNodesContext(ctx context.Context, sourceId string, targetId string) (int64, error) {
+	return g.ConnectNodesWithPropertiesContext(ctx, sourceId, targetId, []byte(`{}`))
+}
+
+func (g *Graph) ConnectNodes(sourceId string, targetId string) (int64, error) {
+	return g.ConnectNodesContext(context.Background(), sourceId, targetId)
+}
+
+func ConnectNodesContext(ctx context.Context, sourceId string, targetId string, database ...string) (int64, error) {
+	return ConnectNodesWithPropertiesContext(ctx, sourceId, targetId, []byte(`{}`), database...)
+}
+
+func ConnectNodes(sourceId string, targetId string, database ...string) (int64, error) {
+	return ConnectNodesContext(context.Background(), sourceId, targetId, database...)
+}
+
+// ConnectNodesUnique leaves the graph alone, returning 0, when any edge
+// from sourceId to targetId already exists, whatever its properties
+func (g *Graph) ConnectNodesUnique(sourceId string, targetId string, properties []byte) (int64, error) {
+	err := validateProperties(properties)
+	if err != nil {
+		return 0, err
+	}
+	sourceId, targetId = edgeEnds(g, sourceId, targetId)
+	return execAffected(context.Background(), g, InsertEdgeIfAbsent, sourceId, targetId, string(properties), sourceId, targetId)
+}
+
+func ConnectNodesUnique(sourceId string, targetId string, properties []byte, database ...string) (int64, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return 0, err
+	}
+	defer graph.Close()
+	return graph.ConnectNodesUnique(sourceId, targetId, properties)
+}
+
+func (g *Graph) BulkConnectNodesWithPropertiesContext(ctx context.Context, sources []string, targets []string, properties []string) (int64, error) {
+	edges, err := makeBulkEdgeInserts(sources, targets, properties)
+	if err != nil {
+		return 0, err
+	}
+	if g.undirected {
+		// each pair has to be checked against the ones already stored
+		batch := make([]EdgeData, 0, len(sources))
+		for i := range sources {
+			batch = append(batch, EdgeData{Source: sources[i], Target: targets[i], Label: properties[i]})
 		}
+		return g.ConnectNodesBatchContext(ctx, batch)
+	}
+	return g.connectMany(ctx, edges, len(sources))
+}
+
+func (g *Graph) BulkConnectNodesWithProperties(sources []string, targets []string, properties []string) (int64, error) {
+	return g.BulkConnectNodesWithPropertiesContext(context.Background(), sources, targets, properties)
+}
+
+func BulkConnectNodesWithPropertiesContext(ctx context.Context, sources []string, targets []string, properties []string, database ...string) (int64, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return 0, err
+	}
+	defer graph.Close()
+	return graph.BulkConnectNodesWithPropertiesContext(ctx, sources, targets, properties)
+}
 
+func BulkConnectNodesWithProperties(sources []string, targets []string, properties []string, database ...string) (int64, error) {
+	return BulkConnectNodesWithPropertiesContext(context.Background(), sources, targets, properties, database...)
+}
+
+func (g *Graph) BulkConnectNodesContext(ctx context.Context, sources []string, targets []string) (int64, error) {
+	l := len(sources)
+	props := make([]string, 0, l)
+	for i := 0; i < l; i++ {
+		props = append(props, `{}`)
+	}
+	return g.BulkConnectNodesWithPropertiesContext(ctx, sources, targets, props)
+}
+
+func (g *Graph) BulkConnectNodes(sources []string, targets []string) (int64, error) {
+	return g.BulkConnectNodesContext(context.Background(), sources, targets)
+}
+
+func BulkConnectNodesContext(ctx context.Context, sources []string, targets []string, database ...string) (int64, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return 0, err
+	}
+	defer graph.Close()
+	return graph.BulkConnectNodesContext(ctx, sources, targets)
+}
+
+func BulkConnectNodes(sources []string, targets []string, database ...string) (int64, error) {
+	return BulkConnectNodesContext(context.Background(), sources, targets, database...)
+}
+
+func (g *Graph) ConnectNodesBatchContext(ctx context.Context, edges []EdgeData) (int64, error) {
+	if err := g.writable(); err != nil {
+		return 0, err
+	}
+	statement := InsertEdge
+	if g.undirected {
+		statement = InsertEdgeIfAbsent
+	}
+	_, stmtErr := g.prepare(ctx, statement)
+	if stmtErr != nil {
+		return 0, stmtErr
+	}
+
+	var count int64
+	err := g.WithTransactionContext(ctx, func(tx *Tx) error {
+		count = 0
+		stmt, err := tx.prepare(ctx, statement)
+		if err != nil {
+			return err
+		}
+		for i, edge := range edges {
+			properties := edge.Label
+			if len(properties) == 0 {
+				properties = `{}`
+			}
+			err := validateProperties([]byte(properties))
+			if err != nil {
+				return fmt.Errorf("edge %d: %w", i, err)
+			}
+			args := []interface{}{edge.Source, edge.Target, properties}
+			if g.undirected {
+				source, target := edgeEnds(g, edge.Source, edge.Target)
+				args = []interface{}{source, target, properties, source, target}
+			}
+			cx, cxErr := stmt.ExecContext(ctx, args...)
+			if cxErr != nil {
+				return fmt.Errorf("edge %d: %w", i, cxErr)
+			}
+			rows, rowsErr := cx.RowsAffected()
+			if rowsErr != nil {
+				return rowsErr
+			}
+			count += rows
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (g *Graph) ConnectNodesBatch(edges []EdgeData) (int64, error) {
+	return g.ConnectNodesBatchContext(context.Background(), edges)
+}
+
+func ConnectNodesBatchContext(ctx context.Context, edges []EdgeData, database ...string) (int64, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return 0, err
+	}
+	defer graph.Close()
+	return graph.ConnectNodesBatchContext(ctx, edges)
+}
+
+func ConnectNodesBatch(edges []EdgeData, database ...string) (int64, error) {
+	return ConnectNodesBatchContext(context.Background(), edges, database...)
+}
+
+// ConnectFromAdjacency connects every source to each of its targets, as
+// ConnectNodesBatch does, going through the sources in sorted order so the
+// edges are always stored in the same order
+func (g *Graph) ConnectFromAdjacency(adjacency map[string][]string) (int64, error) {
+	sources := make([]string, 0, len(adjacency))
+	for source := range adjacency {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	edges := []EdgeData{}
+	for _, source := range sources {
+		for _, target := range adjacency[source] {
+			edges = append(edges, EdgeData{Source: source, Target: target})
+		}
+	}
+	return g.ConnectNodesBatch(edges)
+}
+
+func ConnectFromAdjacency(adjacency map[string][]string, database ...string) (int64, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return 0, err
+	}
+	defer graph.Close()
+	return graph.ConnectFromAdjacency(adjacency)
+}
+
+func removeEdge(ctx context.Context, q querier, sourceId string, targetId string) (int64, error) {
+	sourceId, targetId = edgeEnds(q, sourceId, targetId)
+	return execAffected(ctx, q, DeleteEdgesBetween, sourceId, targetId)
+}
+
+func (g *Graph) RemoveEdgeContext(ctx context.Context, sourceId string, targetId string) (int64, error) {
+	return removeEdge(ctx, g, sourceId, targetId)
+}
+
+func (g *Graph) RemoveEdge(sourceId string, targetId string) (int64, error) {
+	return g.RemoveEdgeContext(context.Background(), sourceId, targetId)
+}
+
+func RemoveEdgeContext(ctx context.Context, sourceId string, targetId string, database ...string) (int64, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return 0, err
+	}
+	defer graph.Close()
+	return graph.RemoveEdgeContext(ctx, sourceId, targetId)
+}
+
+func RemoveEdge(sourceId string, targetId string, database ...string) (int64, error) {
+	return RemoveEdgeContext(context.Background(), sourceId, targetId, database...)
+}
+
+func (g *Graph) RemoveEdgeWithPropertiesContext(ctx context.Context, sourceId string, targetId string, properties []byte) (int64, error) {
+	sourceId, targetId = edgeEnds(g, sourceId, targetId)
+	return execAffected(ctx, g, DeleteOneEdgeBetween, sourceId, targetId, string(properties))
+}
+
+func (g *Graph) RemoveEdgeWithProperties(sourceId string, targetId string, properties []byte) (int64, error) {
+	return g.RemoveEdgeWithPropertiesContext(context.Background(), sourceId, targetId, properties)
+}
+
+func RemoveEdgeWithPropertiesContext(ctx context.Context, sourceId string, targetId string, properties []byte, database ...string) (int64, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return 0, err
+	}
+	defer graph.Close()
+	return graph.RemoveEdgeWithPropertiesContext(ctx, sourceId, targetId, properties)
+}
+
+func RemoveEdgeWithProperties(sourceId string, targetId string, properties []byte, database ...string) (int64, error) {
+	return RemoveEdgeWithPropertiesContext(context.Background(), sourceId, targetId, properties, database...)
+}
+
+// every parallel edge between the pair gets the new properties; use
+// UpdateEdgePropertiesMatching to change just one of them
+func (g *Graph) UpdateEdgeProperties(sourceId string, targetId string, properties []byte) (int64, error) {
+	err := validateProperties(properties)
+	if err != nil {
+		return 0, err
+	}
+	sourceId, targetId = edgeEnds(g, sourceId, targetId)
+	return execAffected(context.Background(), g, UpdateEdgesBetween, string(properties), sourceId, targetId)
+}
+
+func UpdateEdgeProperties(sourceId string, targetId string, properties []byte, database ...string) (int64, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return 0, err
+	}
+	defer graph.Close()
+	return graph.UpdateEdgeProperties(sourceId, targetId, properties)
+}
+
+func (g *Graph) UpdateEdgePropertiesMatching(sourceId string, targetId string, current []byte, properties []byte) (int64, error) {
+	err := validateProperties(properties)
+	if err != nil {
+		return 0, err
+	}
+	sourceId, targetId = edgeEnds(g, sourceId, targetId)
+	return execAffected(context.Background(), g, UpdateOneEdgeBetween, string(properties), sourceId, targetId, string(current))
+}
+
+func UpdateEdgePropertiesMatching(sourceId string, targetId string, current []byte, properties []byte, database ...string) (int64, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return 0, err
+	}
+	defer graph.Close()
+	return graph.UpdateEdgePropertiesMatching(sourceId, targetId, current, properties)
+}
+
+// deleteNodes drops the nodes and every edge touching them with a few IN
+// statements per chunk of ids; it always runs inside a transaction, so a
+// failure part way through never leaves a partial delete behind
+func deleteNodes(t *Tx, identifiers []string) (int64, error) {
+	if err := t.writable(); err != nil {
+		return 0, err
+	}
+	var count int64
+	for start := 0; start < len(identifiers); start += MAX_IDS_PER_QUERY {
+		end := start + MAX_IDS_PER_QUERY
+		if end > len(identifiers) {
+			end = len(identifiers)
+		}
+		chunk := identifierArgs(identifiers[start:end])
+		for _, statement := range []string{DeleteEdgesFromNodes, DeleteEdgesToNodes} {
+			_, err := t.execIn(statement, chunk)
+			if err != nil {
+				return 0, err
+			}
+		}
+		rows, err := t.execIn(DeleteNodesByIds, chunk)
+		if err != nil {
+			return 0, err
+		}
+		count += rows
+	}
+	return count, nil
+}
+
+func (g *Graph) RemoveNodesContext(ctx context.Context, identifiers []string) (int64, error) {
+	if err := g.writable(); err != nil {
+		return 0, err
+	}
+	var count int64
+	err := g.WithTransactionContext(ctx, func(tx *Tx) error {
+		var err error
+		count, err = deleteNodes(tx, identifiers)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (g *Graph) RemoveNodes(identifiers []string) (int64, error) {
+	return g.RemoveNodesContext(context.Background(), identifiers)
+}
+
+func RemoveNodesContext(ctx context.Context, identifiers []string, database ...string) (int64, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return 0, err
+	}
+	defer graph.Close()
+	return graph.RemoveNodesContext(ctx, identifiers)
+}
+
+func RemoveNodes(identifiers []string, database ...string) (int64, error) {
+	return RemoveNodesContext(context.Background(), identifiers, database...)
+}
+
+// Clear deletes every edge and then every node in one transaction, leaving
+// the schema, indexes and schema version as they were
+func (g *Graph) Clear() error {
+	if err := g.writable(); err != nil {
+		return err
+	}
+	return g.WithTransaction(func(tx *Tx) error {
+		_, err := execAffected(tx.ctx, tx, DeleteAllEdges)
+		if err != nil {
+			return err
+		}
+		_, err = execAffected(tx.ctx, tx, DeleteAllNodes)
+		return err
+	})
+}
+
+func Clear(database ...string) error {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return err
+	}
+	defer graph.Close()
+	return graph.Clear()
+}
+
+func findNode(ctx context.Context, q querier, identifier string) (string, error) {
+	stmt, err := q.prepare(ctx, SearchNodeById)
+	if err != nil {
+		return "", err
+	}
+	var body string
+	err = stmt.QueryRowContext(ctx, identifier).Scan(&body)
+	if err == sql.ErrNoRows {
+		return "", notFoundError{identifier, err}
+	}
+	if err != nil {
+		return "", err
+	}
+	return body, nil
+}
+
+func (g *Graph) FindNodeContext(ctx context.Context, identifier string) (string, error) {
+	return findNode(ctx, g, identifier)
+}
+
+func (g *Graph) FindNode(identifier string) (string, error) {
+	return g.FindNodeContext(context.Background(), identifier)
+}
+
+func FindNodeContext(ctx context.Context, identifier string, database ...string) (string, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return "", err
+	}
+	defer graph.Close()
+	return graph.FindNodeContext(ctx, identifier)
+}
+
+func FindNode(identifier string, database ...string) (string, error) {
+	return FindNodeContext(context.Background(), identifier, database...)
+}
+
+func nodeExists(ctx context.Context, q querier, identifier string) (bool, error) {
+	stmt, err := q.prepare(ctx, SearchNodeExists)
+	if err != nil {
+		return false, err
+	}
+	var found int
+	err = stmt.QueryRowContext(ctx, identifier).Scan(&found)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (g *Graph) NodeExists(identifier string) (bool, error) {
+	return nodeExists(context.Background(), g, identifier)
+}
+
+func NodeExists(identifier string, database ...string) (bool, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return false, err
+	}
+	defer graph.Close()
+	return graph.NodeExists(identifier)
+}
+
+// NodesExist reports for each of the ids whether there is a node with it,
+// looking them up in chunks of MAX_IDS_PER_QUERY
+func (g *Graph) NodesExist(identifiers []string) (map[string]bool, error) {
+	exists := make(map[string]bool, len(identifiers))
+	for _, identifier := range identifiers {
+		exists[identifier] = false
+	}
+	for start := 0; start < len(identifiers); start += MAX_IDS_PER_QUERY {
+		end := start + MAX_IDS_PER_QUERY
+		if end > len(identifiers) {
+			end = len(identifiers)
+		}
+		chunk := identifiers[start:end]
+		rows, err := g.db.Query(withInList(SearchNodeIds, len(chunk)), identifierArgs(chunk)...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var identifier string
+			err = rows.Scan(&identifier)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			exists[identifier] = true
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return exists, nil
+}
+
+func NodesExist(identifiers []string, database ...string) (map[string]bool, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return nil, err
+	}
+	defer graph.Close()
+	return graph.NodesExist(identifiers)
+}
+
+func (g *Graph) queryCount(statement string, args ...interface{}) (int64, error) {
+	stmt, err := g.prepare(context.Background(), statement)
+	if err != nil {
+		return 0, err
+	}
+	var count int64
+	err = stmt.QueryRow(args...).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (g *Graph) CountNodes() (int64, error) {
+	return g.queryCount(CountAllNodes)
+}
+
+func CountNodes(database ...string) (int64, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return 0, err
+	}
+	defer graph.Close()
+	return graph.CountNodes()
+}
+
+func (g *Graph) CountEdges() (int64, error) {
+	return g.queryCount(CountAllEdges)
+}
+
+func CountEdges(database ...string) (int64, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return 0, err
+	}
+	defer graph.Close()
+	return graph.CountEdges()
+}
+
+// on an undirected graph InDegree, OutDegree and Degree all count every edge
+// at the node
+func (g *Graph) InDegree(identifier string) (int, error) {
+	if g.undirected {
+		return g.Degree(identifier)
+	}
+	count, err := g.queryCount(CountEdgesTo, identifier)
+	return int(count), err
+}
+
+func InDegree(identifier string, database ...string) (int, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return 0, err
+	}
+	defer graph.Close()
+	return graph.InDegree(identifier)
+}
+
+func (g *Graph) OutDegree(identifier string) (int, error) {
+	if g.undirected {
+		return g.Degree(identifier)
+	}
+	count, err := g.queryCount(CountEdgesFrom, identifier)
+	return int(count), err
+}
+
+func OutDegree(identifier string, database ...string) (int, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return 0, err
+	}
+	defer graph.Close()
+	return graph.OutDegree(identifier)
+}
+
+// a self loop counts twice, once in each direction
+func (g *Graph) Degree(identifier string) (int, error) {
+	in, err := g.queryCount(CountEdgesTo, identifier)
+	if err != nil {
+		return 0, err
+	}
+	out, err := g.queryCount(CountEdgesFrom, identifier)
+	if err != nil {
+		return 0, err
+	}
+	return int(in + out), nil
+}
+
+func Degree(identifier string, database ...string) (int, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return 0, err
+	}
+	defer graph.Close()
+	return graph.Degree(identifier)
+}
+
+func (g *Graph) UpdateNodeBody(identifier string, body string) error {
+	if err := g.writable(); err != nil {
+		return err
+	}
+	if err := g.checkNode([]byte(body)); err != nil {
+		return err
+	}
+	if g.canonical {
+		canonical, err := canonicalize([]byte(body))
+		if err != nil {
+			return err
+		}
+		body = string(canonical)
+	}
+	_, err := execAffected(context.Background(), g, UpdateNodeById, body, identifier)
+	return err
+}
+
+func UpdateNodeBody(identifier string, body string, database ...string) error {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return err
+	}
+	defer graph.Close()
+	return graph.UpdateNodeBody(identifier, body)
+}
+
+func updateNode(ctx context.Context, q querier, identifier string, node []byte) (int64, error) {
+	err := q.checkNode(node)
+	if err != nil {
+		return 0, err
+	}
+	node, err = canonicalNode(q, identifier, node)
+	if err != nil {
+		return 0, err
+	}
+	return execAffected(ctx, q, UpdateNodeKeepingId, string(node), identifier, identifier)
+}
+
+func (g *Graph) UpdateNodeContext(ctx context.Context, identifier string, node []byte) (int64, error) {
+	return updateNode(ctx, g, identifier, node)
+}
+
+func (g *Graph) UpdateNode(identifier string, node []byte) (int64, error) {
+	return g.UpdateNodeContext(context.Background(), identifier, node)
+}
+
+func UpdateNodeContext(ctx context.Context, identifier string, node []byte, database ...string) (int64, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return 0, err
+	}
+	defer graph.Close()
+	return graph.UpdateNodeContext(ctx, identifier, node)
+}
+
+func UpdateNode(identifier string, node []byte, database ...string) (int64, error) {
+	return UpdateNodeContext(context.Background(), identifier, node, database...)
+}
+
+func upsertNode(ctx context.Context, q querier, identifier string, node []byte) (int64, error) {
+	err := q.checkNode(node)
+	if err != nil {
+		return 0, err
+	}
+	node, err = canonicalNode(q, identifier, node)
+	if err != nil {
+		return 0, err
+	}
+	return execAffected(ctx, q, InsertOrUpdateNode, string(node), identifier)
+}
+
+func (g *Graph) UpsertNodeContext(ctx context.Context, identifier string, node []byte) (int64, error) {
+	return upsertNode(ctx, g, identifier, node)
+}
+
+func (g *Graph) UpsertNode(identifier string, node []byte) (int64, error) {
+	return g.UpsertNodeContext(context.Background(), identifier, node)
+}
+
+func UpsertNodeContext(ctx context.Context, identifier string, node []byte, database ...string) (int64, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return 0, err
+	}
+	defer graph.Close()
+	return graph.UpsertNodeContext(ctx, identifier, node)
+}
+
+func UpsertNode(identifier string, node []byte, database ...string) (int64, error) {
+	return UpsertNodeContext(context.Background(), identifier, node, database...)
+}
+
+// PatchNode merges patch into the node's body per RFC 7386: members of the
+// patch replace or add to the body's, nested objects are merged the same
+// way, and a null member removes that key; the id always stays as it was
+func (g *Graph) PatchNode(identifier string, patch []byte) (int64, error) {
+	if err := validatePatch(patch); err != nil {
+		return 0, err
+	}
+	if err := g.writable(); err != nil {
+		return 0, err
+	}
+	if !g.canonical && g.validator == nil {
+		return execAffected(context.Background(), g, UpdateNodeWithPatch, string(patch), identifier, identifier)
+	}
+
+	// json_patch appends new keys, so the result has to be put back in order,
+	// and a validator has to see the whole patched body
+	var count int64
+	err := g.WithTransaction(func(tx *Tx) error {
+		stmt, err := tx.prepare(tx.ctx, SearchPatchedNode)
+		if err != nil {
+			return err
+		}
+		var patched string
+		err = stmt.QueryRowContext(tx.ctx, string(patch), identifier).Scan(&patched)
+		if errors.Is(err, sql.ErrNoRows) {
+			count = 0
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		count, err = updateNode(tx.ctx, tx, identifier, []byte(patched))
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func PatchNode(identifier string, patch []byte, database ...string) (int64, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return 0, err
+	}
+	defer graph.Close()
+	return graph.PatchNode(identifier, patch)
+}
+
+// validatePatch refuses a merge patch that is not a JSON object, which
+// json_patch would otherwise put in place of the whole body
+func validatePatch(patch []byte) error {
+	var members map[string]json.RawMessage
+	if json.Unmarshal(patch, &members) != nil || members == nil {
+		return errors.New(INVALID_NODE_JSON)
+	}
+	return nil
+}
+
+// UpdateNodesWhere merges patch into the body of every node matching the
+// where clause, as PatchNode does for one node, and returns how many nodes
+// matched; args fill the clause's placeholders, after the patch itself
+func (g *Graph) UpdateNodesWhere(where string, args []interface{}, patch []byte) (int64, error) {
+	if len(strings.TrimSpace(where)) == 0 {
+		return 0, errors.New("empty where clause")
+	}
+	if err := validatePatch(patch); err != nil {
+		return 0, err
+	}
+	if err := g.writable(); err != nil {
+		return 0, err
+	}
+	params := append([]interface{}{string(patch)}, args...)
+	if !g.canonical && g.validator == nil {
+		var result sql.Result
+		err := g.retry(context.Background(), func() error {
+			var err error
+			result, err = g.db.Exec(strings.TrimSpace(UpdateNodesWithPatch)+" "+where, params...)
+			return err
+		})
+		if err != nil {
+			return 0, err
+		}
+		return result.RowsAffected()
+	}
+
+	// as in PatchNode, each patched body is read back so it can be put in
+	// order and validated before it is written
+	var count int64
+	err := g.WithTransaction(func(tx *Tx) error {
+		rows, err := tx.tx.QueryContext(tx.ctx, strings.TrimSpace(SearchPatchedNodes)+" "+where, params...)
+		if err != nil {
+			return err
+		}
+		patched := map[string]string{}
+		identifiers := []string{}
+		for rows.Next() {
+			var identifier, body string
+			err = rows.Scan(&identifier, &body)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			identifiers = append(identifiers, identifier)
+			patched[identifier] = body
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		count = 0
+		for _, identifier := range identifiers {
+			updated, err := updateNode(tx.ctx, tx, identifier, []byte(patched[identifier]))
+			if err != nil {
+				return fmt.Errorf("node %q: %w", identifier, err)
+			}
+			count += updated
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func UpdateNodesWhere(where string, args []interface{}, patch []byte, database ...string) (int64, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return 0, err
+	}
+	defer graph.Close()
+	return graph.UpdateNodesWhere(where, args, patch)
+}
+
+func generateWhereClauseForSearch(properties map[string]string, predicate string) string {
+	clauses := []string{}
+	for key := range properties {
+		clause := strings.Builder{}
+		fmt.Fprintf(&clause, "json_extract(body, '$.%s') %s ?", key, predicate)
+		clauses = append(clauses, clause.String())
+	}
+	return strings.Join(clauses, " AND ")
+}
+
+func generateSearchEquals(properties map[string]string) string {
+	return generateWhereClauseForSearch(properties, "=")
+}
+
+func generateSearchLike(properties map[string]string) string {
+	return generateWhereClauseForSearch(properties, "LIKE")
+}
+
+func generateSearchStatement(properties map[string]string, equality bool) string {
+	var where string
+	if equality {
+		where = generateSearchEquals(properties)
+	} else {
+		where = generateSearchLike(properties)
+	}
+	return fmt.Sprintf("%s %s", strings.TrimSpace(SearchNode), where)
+}
+
+func generateSearchBindings(properties map[string]string, startsWith bool, contains bool) []string {
+	bindings := []string{}
+	for _, val := range properties {
+		var binding string
+		if startsWith {
+			binding = fmt.Sprintf("%s%%", val)
+		} else if contains {
+			binding = fmt.Sprintf("%%%s%%", val)
+		} else {
+			binding = val
+		}
+		bindings = append(bindings, binding)
+	}
+	return bindings
+}
+
+func convertSearchBindingsToParameters(bindings []string) []interface{} {
+	params := make([]interface{}, len(bindings))
+	for i, binding := range bindings {
+		params[i] = binding
+	}
+	return params
+}
+
+func (g *Graph) FindNodes(properties map[string]string, startsWith bool, contains bool) ([]string, error) {
+	var statement string
+	if startsWith || contains {
+		statement = generateSearchStatement(properties, false)
+	} else {
+		statement = generateSearchStatement(properties, true)
+	}
+	bindings := generateSearchBindings(properties, startsWith, contains)
+
+	find := func(db *sql.DB) ([]string, error) {
+		stmt, stmtErr := db.Prepare(statement)
+		if stmtErr != nil {
+			return nil, stmtErr
+		}
+		defer stmt.Close()
+
+		results := []string{}
+		rows, err := stmt.Query(convertSearchBindingsToParameters(bindings)...)
+		if err != nil {
+			results = append(results, "")
+			return results, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var body string
+			err = rows.Scan(&body)
+			if err != nil {
+				results = append(results, "")
+				return results, err
+			}
+			results = append(results, body)
+		}
+		err = rows.Err()
+		return results, err
+	}
+
+	return find(g.db)
+}
+
+func FindNodes(properties map[string]string, startsWith bool, contains bool, database ...string) ([]string, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return nil, err
+	}
+	defer graph.Close()
+	return graph.FindNodes(properties, startsWith, contains)
+}
+
+// FindNodesWhere returns the bodies of the nodes matching a WHERE clause,
+// e.g. "json_extract(body, '$.age') > ? AND json_extract(body, '$.city') = ?",
+// with args bound to its placeholders in order. The clause goes into the
+// SQL as it is, so it must be written by the program and never built from
+// user input; only args are safe for untrusted values
+func (g *Graph) FindNodesWhere(where string, args []interface{}) ([]string, error) {
+	if len(strings.TrimSpace(where)) == 0 {
+		return nil, errors.New("empty where clause")
+	}
+	stmt, err := g.db.Prepare(strings.TrimSpace(SearchNode) + " " + where)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	return queryStatementStrings(stmt, args...)
+}
+
+func FindNodesWhere(where string, args []interface{}, database ...string) ([]string, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return nil, err
+	}
+	defer graph.Close()
+	return graph.FindNodesWhere(where, args)
+}
+
+// inList is the "(?, ?, ...)" placeholder list for an IN clause; the
+// statements built with it vary in length, so they are never cached
+func inList(count int) string {
+	return "(" + strings.TrimSuffix(strings.Repeat("?, ", count), ", ") + ")"
+}
+
+// withInList completes a statement ending in IN, such as SearchNodesByIds,
+// with count parameters; callers pass at most MAX_IDS_PER_QUERY ids at a
+// time, well under the 999 variables older SQLite builds allow
+func withInList(statement string, count int) string {
+	return strings.TrimSpace(statement) + " " + inList(count)
+}
+
+func identifierArgs(identifiers []string) []interface{} {
+	args := make([]interface{}, len(identifiers))
+	for i, identifier := range identifiers {
+		args[i] = identifier
+	}
+	return args
+}
+
+func (g *Graph) findNodesByIds(identifiers []string, found map[string]string) error {
+	stmt, err := g.db.Prepare(withInList(SearchNodesByIds, len(identifiers)))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(identifierArgs(identifiers)...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var identifier string
+		var body string
+		err = rows.Scan(&identifier, &body)
+		if err != nil {
+			return err
+		}
+		found[identifier] = body
+	}
+	return rows.Err()
+}
+
+// ids are looked up in chunks to stay well under SQLite's bound variable limit
+func (g *Graph) FindNodesByIds(identifiers []string) (map[string]string, error) {
+	found := map[string]string{}
+	for start := 0; start < len(identifiers); start += MAX_IDS_PER_QUERY {
+		end := start + MAX_IDS_PER_QUERY
+		if end > len(identifiers) {
+			end = len(identifiers)
+		}
+		err := g.findNodesByIds(identifiers[start:end], found)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return found, nil
+}
+
+func FindNodesByIds(identifiers []string, database ...string) (map[string]string, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return nil, err
+	}
+	defer graph.Close()
+	return graph.FindNodesByIds(identifiers)
+}
+
+func propertyPath(key string) (string, error) {
+	if len(key) == 0 || strings.ContainsRune(key, '"') {
+		return "", fmt.Errorf("invalid property key %q", key)
+	}
+	return fmt.Sprintf("$.\"%s\"", key), nil
+}
+
+func (g *Graph) queryStrings(statement string, args ...interface{}) ([]string, error) {
+	stmt, stmtErr := g.prepare(context.Background(), statement)
+	if stmtErr != nil {
+		return nil, stmtErr
+	}
+	return queryStatementStrings(stmt, args...)
+}
+
+func queryStatementStrings(stmt *sql.Stmt, args ...interface{}) ([]string, error) {
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := []string{}
+	for rows.Next() {
+		var value string
+		err = rows.Scan(&value)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, value)
+	}
+	return results, rows.Err()
+}
+
+func queryStatementEdges(stmt *sql.Stmt, args ...interface{}) ([]EdgeData, error) {
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := []EdgeData{}
+	for rows.Next() {
+		var edge EdgeData
+		err = rows.Scan(&edge.Source, &edge.Target, &edge.Label)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, edge)
+	}
+	return results, rows.Err()
+}
+
+func (g *Graph) FindNodesByProperty(key string, value string) ([]string, error) {
+	path, err := propertyPath(key)
+	if err != nil {
+		return nil, err
+	}
+	return g.queryStrings(SearchNodesByProperty, path, value)
+}
+
+func FindNodesByProperty(key string, value string, database ...string) ([]string, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return nil, err
+	}
+	defer graph.Close()
+	return graph.FindNodesByProperty(key, value)
+}
+
+// GetNodesByType finds the nodes whose type is nodeType, whether the body
+// gives its type as a string or as an array with nodeType among the values
+func (g *Graph) GetNodesByType(nodeType string) ([]string, error) {
+	return g.queryStrings(SearchNodesByType, nodeType)
+}
+
+func GetNodesByType(nodeType string, database ...string) ([]string, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return nil, err
+	}
+	defer graph.Close()
+	return graph.GetNodesByType(nodeType)
+}
+
+// the path goes to json_extract as a bound parameter, and a malformed one
+// makes SQLite fail the query rather than match anything
+func (g *Graph) FindNodesByJSONPath(path string, value interface{}) ([]string, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("invalid JSON path %q", path)
+	}
+	switch value.(type) {
+	case string, bool, int, int32, int64, float32, float64:
+	default:
+		return nil, fmt.Errorf("unsupported JSON path value %v of type %T", value, value)
+	}
+	return g.queryStrings(SearchNodesByProperty, path, value)
+}
+
+func FindNodesByJSONPath(path string, value interface{}, database ...string) ([]string, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return nil, err
+	}
+	defer graph.Close()
+	return graph.FindNodesByJSONPath(path, value)
+}
+
+// DistinctValues lists each value json_extract finds at the path across all
+// the nodes, in SQLite's sort order, leaving out nodes where it is missing
+// or null; an array or object comes back as its JSON text, and true and
+// false as 1 and 0
+func (g *Graph) DistinctValues(path string) ([]string, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("invalid JSON path %q", path)
+	}
+	return g.queryStrings(SearchDistinctValues, path)
+}
+
+func DistinctValues(path string, database ...string) ([]string, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return nil, err
+	}
+	defer graph.Close()
+	return graph.DistinctValues(path)
+}
+
+// CountByProperty counts the nodes having each of the values DistinctValues
+// would list; nodes where the path is missing or null are not counted, so
+// CountNodes less the total gives how many of them there are
+func (g *Graph) CountByProperty(path string) (map[string]int64, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("invalid JSON path %q", path)
+	}
+	stmt, err := g.prepare(context.Background(), CountNodesByProperty)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := stmt.Query(path)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := map[string]int64{}
+	for rows.Next() {
+		var value string
+		var count int64
+		err = rows.Scan(&value, &count)
+		if err != nil {
+			return nil, err
+		}
+		// 1 and "1" are grouped apart but share a key here
+		counts[value] += count
+	}
+	return counts, rows.Err()
+}
+
+func CountByProperty(path string, database ...string) (map[string]int64, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return nil, err
 	}
-	return insertMany(args, database...)
+	defer graph.Close()
+	return graph.CountByProperty(path)
 }
 
-func ConnectNodesWithProperties(sourceId string, targetId string, properties []byte, database ...string) (int64, error) {
-	connect := func(db *sql.DB) (sql.Result, error) {
-		stmt, stmtErr := db.Prepare(InsertEdge)
-		evaluate(stmtErr)
-		return stmt.Exec(sourceId, targetId, string(properties))
+// jsonIndexName turns a path into an index name, e.g. "$.type" becomes
+// json_idx_type_ plus a hash of the path, so that paths which only differ in
+// punctuation still get indexes of their own
+func jsonIndexName(path string) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(path))
+	name := strings.Map(func(r rune) rune {
+		if r < 128 && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return r
+		}
+		return '_'
+	}, strings.TrimPrefix(path, "$"))
+	return fmt.Sprintf("json_idx%s_%08x", strings.TrimRight("_"+strings.Trim(name, "_"), "_"), hash.Sum32())
+}
+
+// CreateJSONIndex indexes json_extract(body, path), which SQLite then uses
+// for queries that spell out the same path as a literal, such as FindNodes
+// and FindNodesWhere; FindNodesByJSONPath binds its path, so it cannot
+func (g *Graph) CreateJSONIndex(path string) error {
+	if !strings.HasPrefix(path, "$") {
+		return fmt.Errorf("invalid JSON path %q", path)
 	}
+	if err := g.writable(); err != nil {
+		return err
+	}
+	literal := "'" + strings.ReplaceAll(path, "'", "''") + "'"
+	_, err := g.db.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON nodes(json_extract(body, %s))", jsonIndexName(path), literal))
+	return err
+}
 
-	dbReference, err := resolveDbFileReference(database...)
-	evaluate(err)
-	db, dbErr := sql.Open(SQLITE, dbReference)
-	evaluate(dbErr)
-	defer db.Close()
-	cx, cxErr := connect(db)
-	if cxErr != nil {
-		return 0, cxErr
+func CreateJSONIndex(path string, database ...string) error {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return err
 	}
-	return cx.RowsAffected()
+	defer graph.Close()
+	return graph.CreateJSONIndex(path)
 }
 
-func ConnectNodes(sourceId string, targetId string, database ...string) (int64, error) {
-	return ConnectNodesWithProperties(sourceId, targetId, []byte(`{}`), database...)
+func (g *Graph) DropJSONIndex(path string) error {
+	if !strings.HasPrefix(path, "$") {
+		return fmt.Errorf("invalid JSON path %q", path)
+	}
+	if err := g.writable(); err != nil {
+		return err
+	}
+	_, err := g.db.Exec("DROP INDEX IF EXISTS " + jsonIndexName(path))
+	return err
 }
 
-func BulkConnectNodesWithProperties(sources []string, targets []string, properties []string, database ...string) (int64, error) {
-	l := len(sources)
-	if l != len(targets) && l != len(properties) {
-		evaluate(errors.New("unequal source, target, properties lists"))
+func DropJSONIndex(path string, database ...string) error {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return err
 	}
-	return connectMany(makeBulkEdgeInserts(sources, targets, properties), l, database...)
+	defer graph.Close()
+	return graph.DropJSONIndex(path)
 }
 
-func BulkConnectNodes(sources []string, targets []string, database ...string) (int64, error) {
-	l := len(sources)
-	props := make([]string, 0, l)
-	for i := 0; i < l; i++ {
-		props = append(props, `{}`)
+// pageBounds maps a non-positive limit to SQLite's "no limit" of -1
+func pageBounds(limit int, offset int) (int, int) {
+	if limit <= 0 {
+		limit = -1
 	}
-	return BulkConnectNodesWithProperties(sources, targets, props, database...)
+	if offset < 0 {
+		offset = 0
+	}
+	return limit, offset
 }
 
-func RemoveNodes(identifiers []string, database ...string) bool {
-	delete := func(db *sql.DB) bool {
-		edgeStmt, edgeErr := db.Prepare(DeleteEdge)
-		evaluate(edgeErr)
-		nodeStmt, nodeErr := db.Prepare(DeleteNode)
-		evaluate(nodeErr)
-		tx, txErr := db.Begin()
-		evaluate(txErr)
+func (g *Graph) ListNodes(limit int, offset int) ([]string, error) {
+	limit, offset = pageBounds(limit, offset)
+	return g.queryStrings(SearchAllNodes, limit, offset)
+}
 
-		var err error
-		for _, identifier := range identifiers {
-			_, err = tx.Stmt(edgeStmt).Exec(identifier, identifier)
-			if err != nil {
-				tx.Rollback()
-				return false
-			}
-			_, err = tx.Stmt(nodeStmt).Exec(identifier)
-			if err != nil {
-				tx.Rollback()
-				return false
-			}
-		}
-		tx.Commit()
-		return true
+func ListNodes(limit int, offset int, database ...string) ([]string, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return nil, err
 	}
-
-	dbReference, err := resolveDbFileReference(database...)
-	evaluate(err)
-	db, dbErr := sql.Open(SQLITE, dbReference)
-	evaluate(dbErr)
-	defer db.Close()
-	return delete(db)
+	defer graph.Close()
+	return graph.ListNodes(limit, offset)
 }
 
-func FindNode(identifier string, database ...string) (string, error) {
-	find := func(db *sql.DB) (string, error) {
-		stmt, err := db.Prepare(SearchNodeById)
-		evaluate(err)
-		defer stmt.Close()
+// IterateNodes calls fn with one node body at a time, stopping at the first
+// error fn returns; fn must not use the same in-memory graph, whose only
+// connection is busy reading the rows
+func (g *Graph) IterateNodes(fn func(body string) error) error {
+	stmt, err := g.prepare(context.Background(), SearchAllNodes)
+	if err != nil {
+		return err
+	}
+	rows, err := stmt.Query(-1, 0)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
 		var body string
-		err = stmt.QueryRow(identifier).Scan(&body)
-		if err == sql.ErrNoRows {
-			return "", err
+		err = rows.Scan(&body)
+		if err != nil {
+			return err
+		}
+		err = fn(body)
+		if err != nil {
+			return err
 		}
-		evaluate(err)
-		return body, nil
 	}
-
-	dbReference, err := resolveDbFileReference(database...)
-	evaluate(err)
-	db, dbErr := sql.Open(SQLITE, dbReference)
-	evaluate(dbErr)
-	defer db.Close()
-	return find(db)
+	return rows.Err()
 }
 
-func UpdateNodeBody(identifier string, body string, database ...string) error {
-	update := func(db *sql.DB) error {
-		stmt, err := db.Prepare(UpdateNode)
-		evaluate(err)
-		defer stmt.Close()
-		_, err = stmt.Exec(body, identifier)
+func IterateNodes(fn func(body string) error, database ...string) error {
+	graph, err := NewGraph(database...)
+	if err != nil {
 		return err
 	}
+	defer graph.Close()
+	return graph.IterateNodes(fn)
+}
 
-	dbReference, err := resolveDbFileReference(database...)
-	evaluate(err)
-	db, dbErr := sql.Open(SQLITE, dbReference)
-	evaluate(dbErr)
-	defer db.Close()
-	return update(db)
+// IterateEdgesFrom calls fn with each edge leaving one of the sources, or on
+// an undirected graph touching one, reading them with one query per chunk of
+// sources; edges come grouped by source, and an error from fn stops the
+// iteration and is returned. Each chunk is read before fn sees any of it, so
+// fn may query the graph, even an in-memory one with its single connection
+func (g *Graph) IterateEdgesFrom(sources []string, fn func(edge EdgeData) error) error {
+	// the undirected query binds each source twice
+	size := MAX_IDS_PER_QUERY
+	if g.undirected {
+		size = MAX_IDS_PER_QUERY / 2
+	}
+	for start := 0; start < len(sources); start += size {
+		end := start + size
+		if end > len(sources) {
+			end = len(sources)
+		}
+		err := g.iterateEdgesFrom(sources[start:end], fn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func UpsertNode(identifier string, body string, database ...string) error {
-	update := []byte(body)
-	node, err := FindNode(identifier, database...)
-	if node == "" && err == sql.ErrNoRows {
-		_, err = AddNode(identifier, update, database...)
+func (g *Graph) iterateEdgesFrom(sources []string, fn func(edge EdgeData) error) error {
+	statement := withInList(SearchEdgesFromNodes, len(sources))
+	args := identifierArgs(sources)
+	if g.undirected {
+		statement += " OR target IN " + inList(len(sources))
+		args = append(args, args...)
+	}
+	stmt, err := g.db.Prepare(statement)
+	if err != nil {
 		return err
-	} else {
-		if needsIdentifier(update) {
-			return UpdateNodeBody(identifier, string(setIdentifier(update, identifier)), database...)
+	}
+	edges, err := queryStatementEdges(stmt, args...)
+	stmt.Close()
+	if err != nil {
+		return err
+	}
+	for _, edge := range edges {
+		err = fn(edge)
+		if err != nil {
+			return err
 		}
-		return UpdateNodeBody(identifier, body, database...)
 	}
+	return nil
 }
 
-func generateWhereClauseForSearch(properties map[string]string, predicate string) string {
-	clauses := []string{}
-	for key := range properties {
-		clause := strings.Builder{}
-		fmt.Fprintf(&clause, "json_extract(body, '$.%s') %s ?", key, predicate)
-		clauses = append(clauses, clause.String())
+func IterateEdgesFrom(sources []string, fn func(edge EdgeData) error, database ...string) error {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return err
 	}
-	return strings.Join(clauses, " AND ")
+	defer graph.Close()
+	return graph.IterateEdgesFrom(sources, fn)
 }
 
-func generateSearchEquals(properties map[string]string) string {
-	return generateWhereClauseForSearch(properties, "=")
+func (g *Graph) ListEdges(limit int, offset int) ([]EdgeData, error) {
+	stmt, err := g.prepare(context.Background(), SearchAllEdges)
+	if err != nil {
+		return nil, err
+	}
+	limit, offset = pageBounds(limit, offset)
+	return queryStatementEdges(stmt, limit, offset)
 }
 
-func generateSearchLike(properties map[string]string) string {
-	return generateWhereClauseForSearch(properties, "LIKE")
+func ListEdges(limit int, offset int, database ...string) ([]EdgeData, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return nil, err
+	}
+	defer graph.Close()
+	return graph.ListEdges(limit, offset)
 }
 
-func generateSearchStatement(properties map[string]string, equality bool) string {
-	var where string
-	if equality {
-		where = generateSearchEquals(properties)
-	} else {
-		where = generateSearchLike(properties)
+// FindOrphanNodes returns the ids of the nodes that have no edges at all
+func (g *Graph) FindOrphanNodes() ([]string, error) {
+	return g.queryStrings(SearchOrphanNodes)
+}
+
+func FindOrphanNodes(database ...string) ([]string, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return nil, err
 	}
-	return fmt.Sprintf("%s %s", strings.TrimSpace(SearchNode), where)
+	defer graph.Close()
+	return graph.FindOrphanNodes()
 }
 
-func generateSearchBindings(properties map[string]string, startsWith bool, contains bool) []string {
-	bindings := []string{}
-	for _, val := range properties {
-		var binding string
-		if startsWith {
-			binding = fmt.Sprintf("%s%%", val)
-		} else if contains {
-			binding = fmt.Sprintf("%%%s%%", val)
-		} else {
-			binding = val
-		}
-		bindings = append(bindings, binding)
+// FindSelfLoops returns the edges whose source and target are the same node
+func (g *Graph) FindSelfLoops() ([]EdgeData, error) {
+	stmt, err := g.prepare(context.Background(), SearchSelfLoops)
+	if err != nil {
+		return nil, err
 	}
-	return bindings
+	return queryStatementEdges(stmt)
 }
 
-func convertSearchBindingsToParameters(bindings []string) []interface{} {
-	params := make([]interface{}, len(bindings))
-	for i, binding := range bindings {
-		params[i] = binding
+func FindSelfLoops(database ...string) ([]EdgeData, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return nil, err
 	}
-	return params
+	defer graph.Close()
+	return graph.FindSelfLoops()
 }
 
-func FindNodes(properties map[string]string, startsWith bool, contains bool, database ...string) ([]string, error) {
-	var statement string
-	if startsWith || contains {
-		statement = generateSearchStatement(properties, false)
-	} else {
-		statement = generateSearchStatement(properties, true)
+// RemoveSelfLoops deletes every edge from a node to itself, returning how
+// many there were
+func (g *Graph) RemoveSelfLoops() (int64, error) {
+	return execAffected(context.Background(), g, DeleteSelfLoops)
+}
+
+func RemoveSelfLoops(database ...string) (int64, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return 0, err
 	}
-	bindings := generateSearchBindings(properties, startsWith, contains)
+	defer graph.Close()
+	return graph.RemoveSelfLoops()
+}
 
-	find := func(db *sql.DB) ([]string, error) {
-		stmt, stmtErr := db.Prepare(statement)
-		evaluate(stmtErr)
-		defer stmt.Close()
+type GraphStats struct {
+	Nodes         int64
+	Edges         int64
+	Orphans       int64
+	MaxDegree     int64
+	AverageDegree float64
+}
 
-		results := []string{}
-		rows, err := stmt.Query(convertSearchBindingsToParameters(bindings)...)
+// Stats summarizes the graph with aggregate queries; a node's degree counts
+// its incoming and outgoing edges alike, as Degree does
+func (g *Graph) Stats() (GraphStats, error) {
+	var stats GraphStats
+	for _, count := range []struct {
+		statement string
+		result    *int64
+	}{
+		{CountAllNodes, &stats.Nodes},
+		{CountAllEdges, &stats.Edges},
+		{CountOrphanNodes, &stats.Orphans},
+		{SearchMaxDegree, &stats.MaxDegree},
+	} {
+		value, err := g.queryCount(count.statement)
 		if err != nil {
-			results = append(results, "")
-			return results, err
+			return GraphStats{}, err
 		}
-		defer rows.Close()
-		for rows.Next() {
-			var body string
-			err = rows.Scan(&body)
-			if err != nil {
-				results = append(results, "")
-				return results, err
-			}
-			results = append(results, body)
-		}
-		err = rows.Err()
-		return results, err
+		*count.result = value
 	}
+	if stats.Nodes > 0 {
+		stats.AverageDegree = float64(2*stats.Edges) / float64(stats.Nodes)
+	}
+	return stats, nil
+}
 
-	dbReference, err := resolveDbFileReference(database...)
-	evaluate(err)
-	db, dbErr := sql.Open(SQLITE, dbReference)
-	evaluate(dbErr)
-	defer db.Close()
-	return find(db)
+func Stats(database ...string) (GraphStats, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return GraphStats{}, err
+	}
+	defer graph.Close()
+	return graph.Stats()
 }
 
 func traverse(source string, statement string, target string) func(*sql.DB) ([]string, error) {
 	return func(db *sql.DB) ([]string, error) {
 		stmt, stmtErr := db.Prepare(statement)
-		evaluate(stmtErr)
+		if stmtErr != nil {
+			return nil, stmtErr
+		}
 		defer stmt.Close()
 
 		results := []string{}
@@ -452,30 +2090,40 @@ func traverse(source string, statement string, target string) func(*sql.DB) ([]s
 	}
 }
 
-func TraverseFromTo(source string, target string, traversal string, database ...string) ([]string, error) {
-	dbReference, err := resolveDbFileReference(database...)
-	evaluate(err)
-	db, dbErr := sql.Open(SQLITE, dbReference)
-	evaluate(dbErr)
-	defer db.Close()
+func (g *Graph) TraverseFromTo(source string, target string, traversal string) ([]string, error) {
 	fn := traverse(source, traversal, target)
-	return fn(db)
+	return fn(g.db)
 }
 
-func TraverseFrom(source string, traversal string, database ...string) ([]string, error) {
-	dbReference, err := resolveDbFileReference(database...)
-	evaluate(err)
-	db, dbErr := sql.Open(SQLITE, dbReference)
-	evaluate(dbErr)
-	defer db.Close()
+func TraverseFromTo(source string, target string, traversal string, database ...string) ([]string, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return nil, err
+	}
+	defer graph.Close()
+	return graph.TraverseFromTo(source, target, traversal)
+}
+
+func (g *Graph) TraverseFrom(source string, traversal string) ([]string, error) {
 	fn := traverse(source, traversal, "")
-	return fn(db)
+	return fn(g.db)
+}
+
+func TraverseFrom(source string, traversal string, database ...string) ([]string, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return nil, err
+	}
+	defer graph.Close()
+	return graph.TraverseFrom(source, traversal)
 }
 
 func traverseWithBodies(source string, statement string, target string) func(*sql.DB) ([]GraphData, error) {
 	return func(db *sql.DB) ([]GraphData, error) {
 		stmt, stmtErr := db.Prepare(statement)
-		evaluate(stmtErr)
+		if stmtErr != nil {
+			return nil, stmtErr
+		}
 		defer stmt.Close()
 
 		results := []GraphData{}
@@ -517,30 +2165,40 @@ func traverseWithBodies(source string, statement string, target string) func(*sq
 	}
 }
 
-func TraverseWithBodiesFromTo(source string, target string, traversal string, database ...string) ([]GraphData, error) {
-	dbReference, err := resolveDbFileReference(database...)
-	evaluate(err)
-	db, dbErr := sql.Open(SQLITE, dbReference)
-	evaluate(dbErr)
-	defer db.Close()
+func (g *Graph) TraverseWithBodiesFromTo(source string, target string, traversal string) ([]GraphData, error) {
 	fn := traverseWithBodies(source, traversal, target)
-	return fn(db)
+	return fn(g.db)
 }
 
-func TraverseWithBodiesFrom(source string, traversal string, database ...string) ([]GraphData, error) {
-	dbReference, err := resolveDbFileReference(database...)
-	evaluate(err)
-	db, dbErr := sql.Open(SQLITE, dbReference)
-	evaluate(dbErr)
-	defer db.Close()
+func TraverseWithBodiesFromTo(source string, target string, traversal string, database ...string) ([]GraphData, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return nil, err
+	}
+	defer graph.Close()
+	return graph.TraverseWithBodiesFromTo(source, target, traversal)
+}
+
+func (g *Graph) TraverseWithBodiesFrom(source string, traversal string) ([]GraphData, error) {
 	fn := traverseWithBodies(source, traversal, "")
-	return fn(db)
+	return fn(g.db)
+}
+
+func TraverseWithBodiesFrom(source string, traversal string, database ...string) ([]GraphData, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return nil, err
+	}
+	defer graph.Close()
+	return graph.TraverseWithBodiesFrom(source, traversal)
 }
 
 func neighbors(statement string, queryBinding func(*sql.Stmt) (*sql.Rows, error)) func(*sql.DB) ([]EdgeData, error) {
 	return func(db *sql.DB) ([]EdgeData, error) {
 		stmt, stmtErr := db.Prepare(statement)
-		evaluate(stmtErr)
+		if stmtErr != nil {
+			return nil, stmtErr
+		}
 		defer stmt.Close()
 
 		results := []EdgeData{}
@@ -572,36 +2230,222 @@ func neighbors(statement string, queryBinding func(*sql.Stmt) (*sql.Rows, error)
 	}
 }
 
-func getConnectionsOneWay(identifier string, direction string, database ...string) ([]EdgeData, error) {
+// on an undirected graph either way means every edge at the node
+func (g *Graph) getConnectionsOneWay(identifier string, direction string) ([]EdgeData, error) {
+	if g.undirected {
+		return g.Connections(identifier)
+	}
 	query := func(stmt *sql.Stmt) (*sql.Rows, error) {
 		return stmt.Query(identifier)
 	}
-	dbReference, err := resolveDbFileReference(database...)
-	evaluate(err)
-	db, dbErr := sql.Open(SQLITE, dbReference)
-	evaluate(dbErr)
-	defer db.Close()
 	fn := neighbors(direction, query)
-	return fn(db)
+	return fn(g.db)
+}
+
+func (g *Graph) ConnectionsIn(identifier string) ([]EdgeData, error) {
+	return g.getConnectionsOneWay(identifier, SearchEdgesInbound)
 }
 
 func ConnectionsIn(identifier string, database ...string) ([]EdgeData, error) {
-	return getConnectionsOneWay(identifier, SearchEdgesInbound, database...)
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return nil, err
+	}
+	defer graph.Close()
+	return graph.ConnectionsIn(identifier)
+}
+
+func (g *Graph) ConnectionsOut(identifier string) ([]EdgeData, error) {
+	return g.getConnectionsOneWay(identifier, SearchEdgesOutbound)
 }
 
 func ConnectionsOut(identifier string, database ...string) ([]EdgeData, error) {
-	return getConnectionsOneWay(identifier, SearchEdgesOutbound, database...)
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return nil, err
+	}
+	defer graph.Close()
+	return graph.ConnectionsOut(identifier)
 }
 
-func Connections(identifier string, database ...string) ([]EdgeData, error) {
+func (g *Graph) Connections(identifier string) ([]EdgeData, error) {
 	query := func(stmt *sql.Stmt) (*sql.Rows, error) {
 		return stmt.Query(identifier, identifier)
 	}
-	dbReference, err := resolveDbFileReference(database...)
-	evaluate(err)
-	db, dbErr := sql.Open(SQLITE, dbReference)
-	evaluate(dbErr)
-	defer db.Close()
 	fn := neighbors(SearchEdges, query)
-	return fn(db)
+	return fn(g.db)
+}
+
+func Connections(identifier string, database ...string) ([]EdgeData, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return nil, err
+	}
+	defer graph.Close()
+	return graph.Connections(identifier)
+}
+
+// GetNodeWithEdges reads the node together with the edges that point at it
+// and the ones that leave it, all from the same snapshot of the database; on
+// an undirected graph both hold every edge the node is on, as with
+// GetIncoming and GetOutgoing
+func (g *Graph) GetNodeWithEdges(identifier string) (body string, incoming []EdgeData, outgoing []EdgeData, err error) {
+	err = g.WithTransaction(func(tx *Tx) error {
+		body, err = findNode(tx.ctx, tx, identifier)
+		if err != nil {
+			return err
+		}
+		if g.undirected {
+			stmt, err := tx.prepare(tx.ctx, SearchEdges)
+			if err != nil {
+				return err
+			}
+			incoming, err = queryStatementEdges(stmt, identifier, identifier)
+			outgoing = append([]EdgeData{}, incoming...)
+			return err
+		}
+		// SearchEdgesOutbound matches on the target, SearchEdgesInbound on the source
+		in, err := tx.prepare(tx.ctx, SearchEdgesOutbound)
+		if err != nil {
+			return err
+		}
+		incoming, err = queryStatementEdges(in, identifier)
+		if err != nil {
+			return err
+		}
+		out, err := tx.prepare(tx.ctx, SearchEdgesInbound)
+		if err != nil {
+			return err
+		}
+		outgoing, err = queryStatementEdges(out, identifier)
+		return err
+	})
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return body, incoming, outgoing, nil
+}
+
+func GetNodeWithEdges(identifier string, database ...string) (string, []EdgeData, []EdgeData, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	defer graph.Close()
+	return graph.GetNodeWithEdges(identifier)
+}
+
+func (g *Graph) GetNeighborsIncludingSelf(identifier string) ([]string, error) {
+	return g.queryStrings(SearchNeighbors, identifier, identifier)
+}
+
+func GetNeighborsIncludingSelf(identifier string, database ...string) ([]string, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return nil, err
+	}
+	defer graph.Close()
+	return graph.GetNeighborsIncludingSelf(identifier)
+}
+
+func (g *Graph) GetNeighbors(identifier string) ([]string, error) {
+	ids, err := g.GetNeighborsIncludingSelf(identifier)
+	if err != nil {
+		return nil, err
+	}
+	results := []string{}
+	for _, id := range ids {
+		if id != identifier {
+			results = append(results, id)
+		}
+	}
+	return results, nil
+}
+
+func GetNeighbors(identifier string, database ...string) ([]string, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return nil, err
+	}
+	defer graph.Close()
+	return graph.GetNeighbors(identifier)
+}
+
+// SearchEdgesInbound matches on the source column, so it yields the outgoing edges
+func (g *Graph) GetOutgoing(identifier string) ([]EdgeData, error) {
+	return g.getConnectionsOneWay(identifier, SearchEdgesInbound)
+}
+
+func GetOutgoing(identifier string, database ...string) ([]EdgeData, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return nil, err
+	}
+	defer graph.Close()
+	return graph.GetOutgoing(identifier)
+}
+
+func (g *Graph) GetEdgeProperties(sourceId string, targetId string) ([]string, error) {
+	sourceId, targetId = edgeEnds(g, sourceId, targetId)
+	return g.queryStrings(SearchEdgeProperties, sourceId, targetId)
+}
+
+func GetEdgeProperties(sourceId string, targetId string, database ...string) ([]string, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return nil, err
+	}
+	defer graph.Close()
+	return graph.GetEdgeProperties(sourceId, targetId)
+}
+
+// GetEdgesBetween returns every edge from source to target, in the order
+// they were made
+func (g *Graph) GetEdgesBetween(sourceId string, targetId string) ([]EdgeData, error) {
+	stmt, err := g.prepare(context.Background(), SearchEdgesBetween)
+	if err != nil {
+		return nil, err
+	}
+	sourceId, targetId = edgeEnds(g, sourceId, targetId)
+	return queryStatementEdges(stmt, sourceId, targetId)
+}
+
+func GetEdgesBetween(sourceId string, targetId string, database ...string) ([]EdgeData, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return nil, err
+	}
+	defer graph.Close()
+	return graph.GetEdgesBetween(sourceId, targetId)
+}
+
+// GetEdgesBetweenUndirected also includes the edges from target back to source
+func (g *Graph) GetEdgesBetweenUndirected(sourceId string, targetId string) ([]EdgeData, error) {
+	stmt, err := g.prepare(context.Background(), SearchEdgesBetweenUndirected)
+	if err != nil {
+		return nil, err
+	}
+	return queryStatementEdges(stmt, sourceId, targetId, targetId, sourceId)
+}
+
+func GetEdgesBetweenUndirected(sourceId string, targetId string, database ...string) ([]EdgeData, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return nil, err
+	}
+	defer graph.Close()
+	return graph.GetEdgesBetweenUndirected(sourceId, targetId)
+}
+
+func (g *Graph) GetIncoming(identifier string) ([]EdgeData, error) {
+	return g.getConnectionsOneWay(identifier, SearchEdgesOutbound)
+}
+
+func GetIncoming(identifier string, database ...string) ([]EdgeData, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return nil, err
+	}
+	defer graph.Close()
+	return graph.GetIncoming(identifier)
 }
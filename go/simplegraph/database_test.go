@@ -1,8 +1,18 @@
 package simplegraph
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
 )
 
 const (
@@ -28,7 +38,7 @@ func ErrorMatches(actual error, expected string) bool {
 }
 
 func TestResolveDbFileReference(t *testing.T) {
-	path := "/tmp/database.sqlite?_foreign_keys=true"
+	path := "/tmp/database.sqlite?_foreign_keys=true&_busy_timeout=5000"
 	actualPath, actualPathErr := resolveDbFileReference("/tmp", "database.sqlite")
 	if actualPath != path {
 		t.Errorf("resolveDbFileReference(\"/tmp\", \"database.sqlite\") = %q but expected %q", actualPath, path)
@@ -37,7 +47,7 @@ func TestResolveDbFileReference(t *testing.T) {
 		t.Errorf("resolveDbFileReference(\"/tmp\", \"database.sqlite\") = %q but expected nil", actualPathErr.Error())
 	}
 
-	file := "database.sqlite?_foreign_keys=true"
+	file := "database.sqlite?_foreign_keys=true&_busy_timeout=5000"
 	actualFile, actualFileErr := resolveDbFileReference("database.sqlite")
 	if actualFile != file {
 		t.Errorf("resolveDbFileReference(\"database.sqlite\") = %q but expected %q", actualFile, file)
@@ -56,6 +66,167 @@ func TestResolveDbFileReference(t *testing.T) {
 	}
 }
 
+func TestOptionsReference(t *testing.T) {
+	for _, test := range []struct {
+		opts     []Option
+		names    []string
+		expected string
+	}{
+		{nil, []string{"/tmp", "database.sqlite"}, "/tmp/database.sqlite?_foreign_keys=true&_busy_timeout=5000"},
+		{[]Option{WithForeignKeys(false)}, []string{"database.sqlite"}, "database.sqlite?_foreign_keys=false&_busy_timeout=5000"},
+		{[]Option{WithBusyTimeout(2 * time.Second), WithJournalMode("TRUNCATE")}, []string{"database.sqlite"}, "database.sqlite?_foreign_keys=true&_busy_timeout=2000&_journal_mode=TRUNCATE"},
+		{[]Option{WithWAL()}, []string{"database.sqlite"}, "database.sqlite?_foreign_keys=true&_busy_timeout=5000&_journal_mode=WAL"},
+		{[]Option{WithReadOnly()}, []string{"database.sqlite"}, "file:database.sqlite?mode=ro&_foreign_keys=true&_busy_timeout=5000"},
+		{[]Option{WithWAL()}, []string{IN_MEMORY}, "file::memory:?_foreign_keys=true&_busy_timeout=5000&_journal_mode=WAL"},
+	} {
+		reference, err := newOptions(test.opts).reference(test.names...)
+		if reference != test.expected || err != nil {
+			t.Errorf("reference(%q) = %q,%v but expected %q,nil", test.names, reference, err, test.expected)
+		}
+	}
+}
+
+func TestConcurrentWriters(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	writers, writes := 8, 25
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			graph, err := NewGraphWithOptions([]Option{WithBusyTimeout(10 * time.Second)}, file)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer graph.Close()
+			for i := 0; i < writes; i++ {
+				_, err = graph.AddNode(fmt.Sprintf("%d-%d", w, i), []byte(`{}`))
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("concurrent AddNode() failed: %v", err)
+	}
+
+	count, err := CountNodes(file)
+	if count != int64(writers*writes) || err != nil {
+		t.Errorf("CountNodes() produced %d,%v but expected %d,nil", count, err, writers*writes)
+	}
+}
+
+func TestSharedGraph(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	graph, err := NewGraph(file)
+	if err != nil {
+		t.Fatalf("NewGraph() produced an error %q but expected nil", err.Error())
+	}
+	defer graph.Close()
+
+	// run with -race to check the handle itself, not just the results
+	workers := 200
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(2)
+		identifier := fmt.Sprintf("%d", w)
+		go func() {
+			defer wg.Done()
+			_, err := graph.AddNode(identifier, []byte(`{}`))
+			if err != nil {
+				errs <- err
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := graph.FindNode(identifier)
+			if err != nil && !errors.Is(err, ErrNodeNotFound) {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("concurrent AddNode()/FindNode() failed: %v", err)
+	}
+
+	count, err := graph.CountNodes()
+	if count != int64(workers) || err != nil {
+		t.Errorf("CountNodes() produced %d,%v but expected %d,nil", count, err, workers)
+	}
+	for w := 0; w < workers; w++ {
+		identifier := fmt.Sprintf("%d", w)
+		node, err := graph.FindNode(identifier)
+		if node != `{"id":"`+identifier+`"}` || err != nil {
+			t.Errorf("FindNode(%q) produced %q,%v", identifier, node, err)
+		}
+	}
+}
+
+func TestReadOnlyGraph(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+	AddNode("1", []byte(apple), file)
+	AddNode("2", []byte(woz), file)
+	ConnectNodes("2", "1", file)
+
+	graph, err := OpenReadOnly(file)
+	if err != nil {
+		t.Fatalf("OpenReadOnly() produced an error %q but expected nil", err.Error())
+	}
+	defer graph.Close()
+
+	node, err := graph.FindNode("1")
+	if node != apple || err != nil {
+		t.Errorf("FindNode() produced %q,%v but expected %q,nil", node, err, apple)
+	}
+	err = graph.Initialize()
+	if err != nil {
+		t.Errorf("Initialize() on an up to date database produced %v but expected nil", err)
+	}
+
+	writes := []struct {
+		name  string
+		write func() error
+	}{
+		{"AddNode", func() error { _, err := graph.AddNode("3", []byte(jobs)); return err }},
+		{"AddNodes", func() error { _, err := graph.AddNodes([]string{"3"}, [][]byte{[]byte(jobs)}); return err }},
+		{"ConnectNodes", func() error { _, err := graph.ConnectNodes("1", "2"); return err }},
+		{"BulkConnectNodes", func() error { _, err := graph.BulkConnectNodes([]string{"1"}, []string{"2"}); return err }},
+		{"RemoveEdge", func() error { _, err := graph.RemoveEdge("2", "1"); return err }},
+		{"RemoveNodes", func() error { _, err := graph.RemoveNodes([]string{"1"}); return err }},
+		{"UpdateNode", func() error { _, err := graph.UpdateNode("1", []byte(apple)); return err }},
+		{"MergeNodes", func() error { return graph.MergeNodes("1", "2") }},
+		{"Vacuum", graph.Vacuum},
+	}
+	for _, w := range writes {
+		err := w.write()
+		if !ErrorMatches(err, READ_ONLY) {
+			t.Errorf("%s() on a read-only graph produced %v but expected %q", w.name, err, READ_ONLY)
+		}
+	}
+
+	count, _ := CountNodes(file)
+	if count != 2 {
+		t.Errorf("CountNodes() after the rejected writes produced %d but expected 2", count)
+	}
+}
+
 func arrayContains(slice []string, val string) bool {
 	for _, item := range slice {
 		if item == val {
@@ -145,30 +316,451 @@ func TestMakeBulkInsertStatement(t *testing.T) {
 	}
 }
 
+func TestWithInList(t *testing.T) {
+	for _, test := range []struct {
+		statement string
+		count     int
+		expected  string
+	}{
+		{SearchNodesByIds, 1, `SELECT id, body FROM nodes WHERE id IN (?)`},
+		{SearchNodesByIds, 3, `SELECT id, body FROM nodes WHERE id IN (?, ?, ?)`},
+		{DeleteEdgesToNodes, 2, `DELETE FROM edges WHERE target IN (?, ?)`},
+	} {
+		actual := withInList(test.statement, test.count)
+		if actual != test.expected {
+			t.Errorf("withInList(%q, %d) = %q but expected %q", test.statement, test.count, actual, test.expected)
+		}
+	}
+}
+
 func TestMakeBulkEdgeInserts(t *testing.T) {
 	expected := []string{"3", "1", founded, "4", "1", `{}`}
-	for i, actual := range makeBulkEdgeInserts([]string{"3", "4"}, []string{"1", "1"}, []string{founded, `{}`}) {
+	inserts, err := makeBulkEdgeInserts([]string{"3", "4"}, []string{"1", "1"}, []string{founded, `{}`})
+	if err != nil {
+		t.Errorf("makeBulkEdgeInserts() produced an error %q but expected nil", err.Error())
+	}
+	for i, actual := range inserts {
 		if expected[i] != actual {
 			t.Errorf("generateBulkInsertStatement() = %q but expected %q", actual, expected[i])
 		}
 	}
+
+	_, err = makeBulkEdgeInserts([]string{"3", "4"}, []string{"1"}, []string{founded, `{}`})
+	if !ErrorMatches(err, "unequal edge lists") {
+		t.Errorf("makeBulkEdgeInserts() produced %v but expected %q", err, "unequal edge lists")
+	}
 }
 
 func TestNodeDataInspection(t *testing.T) {
-	missing := needsIdentifier([]byte(`{"status": 404,"result": "error", "reason": "Not found"}`))
+	missing, _ := needsIdentifier([]byte(`{"status": 404,"result": "error", "reason": "Not found"}`))
 	if !missing {
 		t.Errorf("needsIdentifier() said false but expected true")
 	}
 
-	alsoMissing := needsIdentifier([]byte(`{"status": 404,"result": "error", "logger": {"id": "9c26f784-b0d6-45ed-aba4-7c333f78babf"}, "reason": "Not found"}`))
+	alsoMissing, _ := needsIdentifier([]byte(`{"status": 404,"result": "error", "logger": {"id": "9c26f784-b0d6-45ed-aba4-7c333f78babf"}, "reason": "Not found"}`))
 	if !alsoMissing {
 		t.Errorf("needsIdentifier() said false but expected true")
 	}
 
-	present := needsIdentifier([]byte(`{"status": 404,"result": "error", "id": "16fd2706-8baf-433b-82eb-8c7fada847da", "reason": "Not found"}`))
+	present, _ := needsIdentifier([]byte(`{"status": 404,"result": "error", "id": "16fd2706-8baf-433b-82eb-8c7fada847da", "reason": "Not found"}`))
 	if present {
 		t.Errorf("needsIdentifier() said true but expected false")
 	}
+
+	_, err := needsIdentifier([]byte(`{"status": 404,`))
+	if err == nil {
+		t.Errorf("needsIdentifier() produced nil but expected an error")
+	}
+}
+
+func TestSetIdentifier(t *testing.T) {
+	for node, expected := range map[string]string{
+		`{}`:                          `{"id":"x"}`,
+		"{ \n }":                      `{"id":"x"}`,
+		`{"name":"a"}`:                `{"id":"x","name":"a"}`,
+		`{"name":"a","meta":{"x":1}}`: `{"id":"x","meta":{"x":1},"name":"a"}`,
+		"{\"name\":\"a\"}\n\t ":       `{"id":"x","name":"a"}`,
+		"  {\"name\":\"a\"}":          `{"id":"x","name":"a"}`,
+		`{"name":"}", "id": 7}`:       `{"id":"x","name":"}"}`,
+		`{"url":"a?b=1&c=<d>"}`:       `{"id":"x","url":"a?b=1&c=<d>"}`,
+	} {
+		actual, err := setIdentifier([]byte(node), "x")
+		if err != nil {
+			t.Errorf("setIdentifier(%q) produced an error %q but expected nil", node, err.Error())
+		}
+		if string(actual) != expected {
+			t.Errorf("setIdentifier(%q) = %q but expected %q", node, actual, expected)
+		}
+		if !json.Valid(actual) {
+			t.Errorf("setIdentifier(%q) = %q which is not valid JSON", node, actual)
+		}
+	}
+
+	actual, _ := setIdentifier([]byte(`{}`), `say "x"`)
+	if string(actual) != `{"id":"say \"x\""}` {
+		t.Errorf("setIdentifier() = %q but expected the identifier to be escaped", actual)
+	}
+
+	for _, node := range []string{`[1, 2]`, `"x"`, `null`, `42`, ``} {
+		actual, err := setIdentifier([]byte(node), "x")
+		if !ErrorMatches(err, "node body is not a JSON object") {
+			t.Errorf("setIdentifier(%q) = %q,%v but expected an error", node, actual, err)
+		}
+	}
+}
+
+func TestInMemoryGraphsAreSeparate(t *testing.T) {
+	a, _ := NewInMemoryGraph()
+	defer a.Close()
+	b, _ := NewInMemoryGraph()
+	defer b.Close()
+	a.Initialize()
+	b.Initialize()
+
+	a.AddNode("1", []byte(apple))
+	count, err := b.CountNodes()
+	if count != 0 || err != nil {
+		t.Errorf("CountNodes() on a second in-memory graph produced %d,%v but expected 0,nil", count, err)
+	}
+	count, err = a.CountNodes()
+	if count != 1 || err != nil {
+		t.Errorf("CountNodes() on the first in-memory graph produced %d,%v but expected 1,nil", count, err)
+	}
+}
+
+func TestInMemoryGraph(t *testing.T) {
+	expected := "file::memory:?_foreign_keys=true&_busy_timeout=5000"
+	reference, err := resolveDbFileReference(IN_MEMORY)
+	if reference != expected || err != nil {
+		t.Errorf("resolveDbFileReference(%q) = %q,%v but expected %q,nil", IN_MEMORY, reference, err, expected)
+	}
+
+	graph, err := NewInMemoryGraph()
+	if err != nil {
+		t.Fatalf("NewInMemoryGraph() produced an error %q but expected nil", err.Error())
+	}
+	defer graph.Close()
+
+	err = graph.Initialize()
+	if err != nil {
+		t.Fatalf("Initialize() produced an error %q but expected nil", err.Error())
+	}
+	graph.AddNode("1", []byte(apple))
+	graph.AddNode("2", []byte(woz))
+
+	node, err := graph.FindNode("1")
+	if node != apple || err != nil {
+		t.Errorf("FindNode() produced %q,%v but expected %q,nil", node, err, apple)
+	}
+
+	count, err := graph.ConnectNodes("2", "1")
+	if count != 1 || err != nil {
+		t.Errorf("ConnectNodes() produced %d,%v but expected 1,nil", count, err)
+	}
+	_, err = graph.ConnectNodes("2", "99")
+	if !ErrorMatches(err, `target node "99" does not exist`) {
+		t.Errorf("ConnectNodes() produced %v but expected a missing target error", err)
+	}
+}
+
+func TestWithMaxConns(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	graph, err := NewGraphWithOptions([]Option{WithMaxOpenConns(1), WithMaxIdleConns(1)}, file)
+	if err != nil {
+		t.Fatalf("NewGraphWithOptions() produced an error %q but expected nil", err.Error())
+	}
+	defer graph.Close()
+	if max := graph.db.Stats().MaxOpenConnections; max != 1 {
+		t.Errorf("WithMaxOpenConns(1) produced a pool of %d but expected 1", max)
+	}
+
+	// with one connection, a second query has to wait for the first to finish
+	done := make(chan error)
+	err = graph.WithTransaction(func(tx *Tx) error {
+		go func() {
+			_, err := graph.CountNodes()
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			t.Errorf("CountNodes() ran alongside the open transaction with %v", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("WithTransaction() produced %v but expected nil", err)
+	}
+	if err = <-done; err != nil {
+		t.Errorf("CountNodes() after the transaction produced %v but expected nil", err)
+	}
+
+	memory, _ := NewGraphWithOptions([]Option{WithMaxOpenConns(4)}, IN_MEMORY)
+	defer memory.Close()
+	if max := memory.db.Stats().MaxOpenConnections; max != 1 {
+		t.Errorf("WithMaxOpenConns(4) in memory produced a pool of %d but expected 1", max)
+	}
+}
+
+func TestWithWAL(t *testing.T) {
+	file := "testdb.sqlite3"
+	defer func() {
+		os.Remove(file)
+		os.Remove(file + "-wal")
+		os.Remove(file + "-shm")
+	}()
+
+	writer, err := NewGraphWithOptions([]Option{WithWAL()}, file)
+	if err != nil {
+		t.Fatalf("NewGraphWithOptions() produced an error %q but expected nil", err.Error())
+	}
+	defer writer.Close()
+	writer.Initialize()
+
+	var mode string
+	err = writer.db.QueryRow("PRAGMA journal_mode").Scan(&mode)
+	if mode != "wal" || err != nil {
+		t.Errorf("PRAGMA journal_mode produced %q,%v but expected \"wal\",nil", mode, err)
+	}
+
+	reader, err := NewGraphWithOptions([]Option{WithWAL()}, file)
+	if err != nil {
+		t.Fatalf("NewGraphWithOptions() produced an error %q but expected nil", err.Error())
+	}
+	defer reader.Close()
+
+	// the reader keeps seeing the last committed state while a write is open
+	err = writer.WithTransaction(func(tx *Tx) error {
+		_, err := tx.AddNode("1", []byte(apple))
+		if err != nil {
+			return err
+		}
+		count, err := reader.CountNodes()
+		if count != 0 || err != nil {
+			t.Errorf("CountNodes() during a write produced %d,%v but expected 0,nil", count, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("WithTransaction() produced an error %q but expected nil", err.Error())
+	}
+
+	count, err := reader.CountNodes()
+	if count != 1 || err != nil {
+		t.Errorf("CountNodes() after the write produced %d,%v but expected 1,nil", count, err)
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	file := "testdb.sqlite3"
+	defer func() {
+		os.Remove(file)
+		os.Remove(file + "-wal")
+		os.Remove(file + "-shm")
+	}()
+
+	graph, err := NewGraphWithOptions([]Option{WithWAL()}, file)
+	if err != nil {
+		t.Fatalf("NewGraphWithOptions() produced an error %q but expected nil", err.Error())
+	}
+	defer graph.Close()
+	graph.Initialize()
+	graph.AddNode("1", []byte(apple))
+
+	info, err := os.Stat(file + "-wal")
+	if err != nil || info.Size() == 0 {
+		t.Fatalf("the write left a log of %v,%v but expected some entries", info, err)
+	}
+	err = graph.Checkpoint()
+	if err != nil {
+		t.Errorf("Checkpoint() produced an error %q but expected nil", err.Error())
+	}
+	info, err = os.Stat(file + "-wal")
+	if err != nil || info.Size() != 0 {
+		t.Errorf("Checkpoint() left a log of %v,%v but expected it to be emptied", info, err)
+	}
+	count, err := graph.CountNodes()
+	if count != 1 || err != nil {
+		t.Errorf("CountNodes() after Checkpoint() produced %d,%v but expected 1,nil", count, err)
+	}
+}
+
+func TestCheckpointWithoutWAL(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	err := Checkpoint(file)
+	if err != nil {
+		t.Errorf("Checkpoint() without WAL produced an error %q but expected nil", err.Error())
+	}
+	readOnly, _ := OpenReadOnly(file)
+	defer readOnly.Close()
+	err = readOnly.Checkpoint()
+	if !ErrorMatches(err, READ_ONLY) {
+		t.Errorf("Checkpoint() on a read-only graph produced %v but expected %q", err, READ_ONLY)
+	}
+}
+
+func TestExplainQueryPlan(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	for _, test := range []struct {
+		statement string
+		args      []interface{}
+		expected  string
+	}{
+		{SearchNodeById, []interface{}{"1"}, "USING INDEX"},
+		{"SELECT body FROM nodes WHERE json_extract(body, '$.type') = ?", []interface{}{"company"}, "SCAN"},
+		{strings.TrimSpace(SearchEdgesInbound), []interface{}{"1"}, "source_idx"},
+		// GetIncoming and InDegree look edges up by target, which has its own index
+		{strings.TrimSpace(SearchEdgesOutbound), []interface{}{"1"}, "target_idx"},
+		{strings.TrimSpace(CountEdgesTo), []interface{}{"1"}, "target_idx"},
+		{strings.TrimSpace(CountEdgesFrom), []interface{}{"1"}, "source_idx"},
+		{withInList(SearchSourcesOfNodes, 2), []interface{}{"1", "2"}, "target_idx"},
+	} {
+		plan, err := ExplainQueryPlan(test.statement, test.args, file)
+		if len(plan) == 0 || !strings.Contains(strings.Join(plan, "\n"), test.expected) || err != nil {
+			t.Errorf("ExplainQueryPlan(%q) produced %q,%v but expected it to mention %q", test.statement, plan, err, test.expected)
+		}
+	}
+
+	// subqueries are indented under the step they belong to
+	plan, err := ExplainQueryPlan(SearchOrphanNodes, nil, file)
+	if len(plan) < 2 || !strings.HasPrefix(plan[len(plan)-1], "  ") || err != nil {
+		t.Errorf("ExplainQueryPlan() produced %q,%v but expected nested steps", plan, err)
+	}
+
+	_, err = ExplainQueryPlan("SELECT body FROM missing", nil, file)
+	if !ErrorMatches(err, "no such table: missing") {
+		t.Errorf("ExplainQueryPlan() produced %v but expected an error for a missing table", err)
+	}
+}
+
+func TestJSONIndex(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+	AddNodes([]string{"1", "2"}, [][]byte{[]byte(apple), []byte(woz)}, file)
+
+	for path, expected := range map[string]string{
+		"$.type":         "json_idx_type_",
+		"$.address.city": "json_idx_address_city_",
+		"$.address_city": "json_idx_address_city_",
+		"$":              "json_idx_",
+	} {
+		name := jsonIndexName(path)
+		if !strings.HasPrefix(name, expected) || len(name) != len(expected)+8 {
+			t.Errorf("jsonIndexName(%q) produced %q but expected %q and a hash", path, name, expected)
+		}
+	}
+	if jsonIndexName("$.address.city") == jsonIndexName("$.address_city") {
+		t.Errorf("jsonIndexName() gave two different paths the same name")
+	}
+
+	query := generateSearchStatement(map[string]string{"name": "Steve Wozniak"}, true)
+	usesIndex := func() bool {
+		plan, err := ExplainQueryPlan(query, []interface{}{"Steve Wozniak"}, file)
+		if err != nil {
+			t.Fatalf("ExplainQueryPlan() produced an error %q but expected nil", err.Error())
+		}
+		return strings.Contains(strings.Join(plan, "\n"), jsonIndexName("$.name"))
+	}
+	if usesIndex() {
+		t.Errorf("ExplainQueryPlan() used an index on $.name before it was created")
+	}
+
+	err := CreateJSONIndex("$.name", file)
+	if err != nil {
+		t.Fatalf("CreateJSONIndex() produced an error %q but expected nil", err.Error())
+	}
+	err = CreateJSONIndex("$.name", file)
+	if err != nil {
+		t.Errorf("CreateJSONIndex() on an existing index produced an error %q but expected nil", err.Error())
+	}
+	if !usesIndex() {
+		t.Errorf("ExplainQueryPlan() did not use the index created on $.name")
+	}
+	nodes, err := FindNodes(map[string]string{"name": "Steve Wozniak"}, false, false, file)
+	if len(nodes) != 1 || nodes[0] != woz || err != nil {
+		t.Errorf("FindNodes() with the index produced %v,%v but expected [%s],nil", nodes, err, woz)
+	}
+
+	err = DropJSONIndex("$.name", file)
+	if err != nil || usesIndex() {
+		t.Errorf("DropJSONIndex() produced %v but expected the index to be gone", err)
+	}
+
+	for _, path := range []string{"name", "'); DROP TABLE nodes; --"} {
+		err = CreateJSONIndex(path, file)
+		if !ErrorMatches(err, fmt.Sprintf("invalid JSON path %q", path)) {
+			t.Errorf("CreateJSONIndex(%q) produced %v but expected an invalid path error", path, err)
+		}
+	}
+	err = CreateJSONIndex("$.it's", file)
+	count, _ := CountNodes(file)
+	if err != nil || count != 2 {
+		t.Errorf("CreateJSONIndex() with a quote in the path produced %v and left %d nodes", err, count)
+	}
+}
+
+func TestVacuumAndAnalyze(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	identifiers, nodes := makeBenchmarkNodes(2000)
+	AddNodes(identifiers, nodes, file)
+	RemoveNodes(identifiers, file)
+
+	before, _ := os.Stat(file)
+	err := Vacuum(file)
+	if err != nil {
+		t.Errorf("Vacuum() produced an error %q but expected nil", err.Error())
+	}
+	after, _ := os.Stat(file)
+	if after.Size() >= before.Size() {
+		t.Errorf("Vacuum() left the file at %d bytes but expected less than %d", after.Size(), before.Size())
+	}
+
+	err = Analyze(file)
+	if err != nil {
+		t.Errorf("Analyze() produced an error %q but expected nil", err.Error())
+	}
+}
+
+func TestUnwritableDatabase(t *testing.T) {
+	file := "/nonexistent/testdb.sqlite3"
+
+	err := Initialize(file)
+	if err == nil {
+		t.Errorf("Initialize() produced nil but expected an error")
+	}
+
+	count, err := AddNode("1", []byte(apple), file)
+	if count != 0 || err == nil {
+		t.Errorf("AddNode() inserted %d,%v but expected 0 and an error", count, err)
+	}
+
+	count, err = ConnectNodesWithProperties("2", "1", []byte(founded), file)
+	if count != 0 || err == nil {
+		t.Errorf("ConnectNodesWithProperties() inserted %d,%v but expected 0 and an error", count, err)
+	}
+
+	node, err := FindNode("1", file)
+	if node != "" || err == nil {
+		t.Errorf("FindNode() produced %q,%v but expected \"\" and an error", node, err)
+	}
+
+	count, err = RemoveNodes([]string{"1"}, file)
+	if count != 0 || err == nil {
+		t.Errorf("RemoveNodes() removed %d,%v but expected 0 and an error", count, err)
+	}
 }
 
 func TestInitializeAndCrudAndSearch(t *testing.T) {
@@ -255,8 +847,8 @@ func TestInitializeAndCrudAndSearch(t *testing.T) {
 	}
 
 	node, err = FindNode("7", file)
-	if node != "" && !ErrorMatches(err, NO_ROWS_FOUND) {
-		t.Errorf("FindNode() produced %q,%q but expected %q,%q", node, err.Error(), "", NO_ROWS_FOUND)
+	if node != "" || !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("FindNode() produced %q,%v but expected %q,%v", node, err, "", ErrNodeNotFound)
 	}
 
 	nodes, err := FindNodes(map[string]string{"name": "Steve"}, true, false, file)
@@ -274,8 +866,12 @@ func TestInitializeAndCrudAndSearch(t *testing.T) {
 	if err != nil {
 		t.Errorf("UpdateNodeBody() produced %q but expected nil", err.Error())
 	}
+	err = UpdateNodeBody("2", `{"name":`, file)
+	if !ErrorMatches(err, INVALID_NODE_JSON) {
+		t.Errorf("UpdateNodeBody() produced %v but expected %q", err, INVALID_NODE_JSON)
+	}
 
-	err = UpsertNode("1", apple, file)
+	_, err = UpsertNode("1", []byte(apple), file)
 	if err != nil {
 		t.Errorf("UpsertNode() produced %q but expected nil", err.Error())
 	}
@@ -386,18 +982,24 @@ func TestInitializeAndCrudAndSearch(t *testing.T) {
 		}
 	}
 
-	if !RemoveNodes([]string{"2", "4"}, file) {
-		t.Error("RemoveNodes() returned false but expected true")
+	removed, err := RemoveNodes([]string{"2", "4"}, file)
+	if removed != 2 || err != nil {
+		t.Errorf("RemoveNodes() removed %d,%v but expected 2,nil", removed, err)
+	}
+
+	removed, err = RemoveNodes([]string{"2", "99"}, file)
+	if removed != 0 || err != nil {
+		t.Errorf("RemoveNodes() removed %d,%v but expected 0,nil", removed, err)
 	}
 
 	node, err = FindNode("2", file)
-	if node != "" && !ErrorMatches(err, NO_ROWS_FOUND) {
-		t.Errorf("FindNode() produced %q,%q but expected %q,%q", node, err.Error(), "", NO_ROWS_FOUND)
+	if node != "" || !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("FindNode() produced %q,%v but expected %q,%v", node, err, "", ErrNodeNotFound)
 	}
 
 	node, err = FindNode("4", file)
-	if node != "" && !ErrorMatches(err, NO_ROWS_FOUND) {
-		t.Errorf("FindNode() produced %q,%q but expected %q,%q", node, err.Error(), "", NO_ROWS_FOUND)
+	if node != "" || !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("FindNode() produced %q,%v but expected %q,%v", node, err, "", ErrNodeNotFound)
 	}
 }
 
@@ -444,3 +1046,2049 @@ func TestBulkInserts(t *testing.T) {
 		t.Errorf("BulkConnectNodes() inserted %d,%q but expected 1,nil", count, err.Error())
 	}
 }
+
+func TestBulkRemoveNodes(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	// enough ids to need more than one chunk, chained so every node has edges
+	identifiers, nodes := makeBenchmarkNodes(MAX_IDS_PER_QUERY*2 + 100)
+	AddNodes(identifiers, nodes, file)
+	BulkConnectNodes(identifiers[1:], identifiers[:len(identifiers)-1], file)
+
+	last := len(identifiers) - 10
+	removed, err := RemoveNodes(identifiers[:last], file)
+	if removed != int64(last) || err != nil {
+		t.Errorf("RemoveNodes() removed %d,%v but expected %d,nil", removed, err, last)
+	}
+	nodeCount, _ := CountNodes(file)
+	edgeCount, _ := CountEdges(file)
+	if nodeCount != 10 || edgeCount != 9 {
+		t.Errorf("RemoveNodes() left %d nodes and %d edges but expected 10 and 9", nodeCount, edgeCount)
+	}
+
+	// a failure later in the same transaction undoes the whole delete
+	err = WithTransaction(func(tx *Tx) error {
+		tx.RemoveNodes(identifiers[last:])
+		return errors.New("abort")
+	}, file)
+	nodeCount, _ = CountNodes(file)
+	if nodeCount != 10 || !ErrorMatches(err, "abort") {
+		t.Errorf("RemoveNodes() in an aborted transaction left %d nodes,%v but expected 10,abort", nodeCount, err)
+	}
+}
+
+func TestPingAndClose(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	graph, _ := NewGraph(file)
+	err := graph.Ping(context.Background())
+	if err != nil {
+		t.Errorf("Ping() produced an error %q but expected nil", err.Error())
+	}
+	graph.FindNode("1")
+
+	missing, _ := OpenReadOnly("testmissing.sqlite3")
+	err = missing.Ping(context.Background())
+	if err == nil {
+		t.Errorf("Ping() on a database that does not exist produced nil but expected an error")
+	}
+	missing.Close()
+
+	for i := 0; i < 2; i++ {
+		err = graph.Close()
+		if err != nil {
+			t.Errorf("Close() #%d produced an error %q but expected nil", i+1, err.Error())
+		}
+	}
+	err = graph.Ping(context.Background())
+	if !ErrorMatches(err, "sql: database is closed") {
+		t.Errorf("Ping() after Close() produced %v but expected the database to be closed", err)
+	}
+	_, err = graph.FindNode("1")
+	if !ErrorMatches(err, "sql: database is closed") {
+		t.Errorf("FindNode() after Close() produced %v but expected the database to be closed", err)
+	}
+}
+
+func TestFromDB(t *testing.T) {
+	file := "testdb.sqlite3"
+	defer os.Remove(file)
+	db, err := sql.Open(SQLITE, file+"?_foreign_keys=true")
+	if err != nil {
+		t.Fatalf("sql.Open() produced an error %q but expected nil", err.Error())
+	}
+	defer db.Close()
+	db.Exec("CREATE TABLE accounts (name TEXT)")
+	db.Exec("INSERT INTO accounts VALUES ('sales')")
+
+	graph := FromDB(db)
+	err = graph.Initialize()
+	if err != nil {
+		t.Fatalf("Initialize() produced an error %q but expected nil", err.Error())
+	}
+	graph.AddNode("1", []byte(apple))
+	err = graph.Close()
+	if err != nil {
+		t.Errorf("Close() produced an error %q but expected nil", err.Error())
+	}
+
+	// the caller's handle, and their own tables, are still there
+	var nodes, accounts int
+	err = db.QueryRow("SELECT (SELECT count(*) FROM nodes), (SELECT count(*) FROM accounts)").Scan(&nodes, &accounts)
+	if nodes != 1 || accounts != 1 || err != nil {
+		t.Errorf("db after Close() produced %d,%d,%v but expected 1,1,nil", nodes, accounts, err)
+	}
+
+	readOnly := FromDB(db, WithReadOnly())
+	defer readOnly.Close()
+	_, err = readOnly.AddNode("2", []byte(woz))
+	if !ErrorMatches(err, READ_ONLY) {
+		t.Errorf("AddNode() through FromDB(WithReadOnly()) produced %v but expected %q", err, READ_ONLY)
+	}
+	node, err := readOnly.FindNode("1")
+	if node != apple || err != nil {
+		t.Errorf("FindNode() through FromDB() produced %q,%v but expected %q,nil", node, err, apple)
+	}
+}
+
+func TestSetForeignKeys(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+	graph, _ := NewGraph(file)
+	defer graph.Close()
+	graph.AddNode("1", []byte(apple))
+
+	// hold several connections at once, so the pool has more than one to check
+	pooled := func() []int {
+		ctx := context.Background()
+		conns := []*sql.Conn{}
+		settings := []int{}
+		for i := 0; i < 3; i++ {
+			conn, err := graph.db.Conn(ctx)
+			if err != nil {
+				t.Fatalf("Conn() produced an error %q but expected nil", err.Error())
+			}
+			conns = append(conns, conn)
+			var setting int
+			conn.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&setting)
+			settings = append(settings, setting)
+		}
+		for _, conn := range conns {
+			conn.Close()
+		}
+		return settings
+	}
+	if settings := pooled(); fmt.Sprint(settings) != "[1 1 1]" {
+		t.Errorf("PRAGMA foreign_keys produced %v but expected [1 1 1]", settings)
+	}
+
+	err := graph.SetForeignKeys(false)
+	if err != nil {
+		t.Fatalf("SetForeignKeys() produced an error %q but expected nil", err.Error())
+	}
+	if settings := pooled(); fmt.Sprint(settings) != "[0 0 0]" {
+		t.Errorf("PRAGMA foreign_keys after SetForeignKeys(false) produced %v but expected [0 0 0]", settings)
+	}
+	count, err := graph.BulkConnectNodes([]string{"1"}, []string{"2"})
+	if count != 1 || err != nil {
+		t.Errorf("BulkConnectNodes() without foreign keys produced %d,%v but expected 1,nil", count, err)
+	}
+	graph.AddNode("2", []byte(woz))
+
+	graph.SetForeignKeys(true)
+	if settings := pooled(); fmt.Sprint(settings) != "[1 1 1]" {
+		t.Errorf("PRAGMA foreign_keys after SetForeignKeys(true) produced %v but expected [1 1 1]", settings)
+	}
+	_, err = graph.BulkConnectNodes([]string{"1"}, []string{"3"})
+	if !ErrorMatches(err, "FOREIGN KEY constraint failed") {
+		t.Errorf("BulkConnectNodes() with foreign keys produced %v but expected a foreign key error", err)
+	}
+
+	db, _ := sql.Open(SQLITE, file)
+	defer db.Close()
+	err = FromDB(db).SetForeignKeys(false)
+	if err == nil {
+		t.Errorf("SetForeignKeys() on a graph from FromDB() produced nil but expected an error")
+	}
+}
+
+func TestGraphHandle(t *testing.T) {
+	file := "testdb.sqlite3"
+	graph, err := NewGraph(file)
+	if err != nil {
+		t.Errorf("NewGraph() produced error %q but expected nil", err.Error())
+	}
+	defer os.Remove(file)
+	defer graph.Close()
+
+	err = graph.Initialize()
+	if err != nil {
+		t.Errorf("Initialize() produced error %q but expected nil", err.Error())
+	}
+
+	count, err := graph.AddNode("1", []byte(apple))
+	if count != 1 || err != nil {
+		t.Errorf("AddNode() inserted %d,%v but expected 1,nil", count, err)
+	}
+
+	count, err = graph.AddNode("3", []byte(jobs))
+	if count != 1 || err != nil {
+		t.Errorf("AddNode() inserted %d,%v but expected 1,nil", count, err)
+	}
+
+	count, err = graph.ConnectNodesWithProperties("3", "1", []byte(founded))
+	if count != 1 || err != nil {
+		t.Errorf("ConnectNodesWithProperties() inserted %d,%v but expected 1,nil", count, err)
+	}
+
+	node, err := graph.FindNode("3")
+	if node != jobs || err != nil {
+		t.Errorf("FindNode() produced %q,%v but expected %q,nil", node, err, jobs)
+	}
+
+	edges, err := graph.Connections("1")
+	if err != nil {
+		t.Errorf("Connections() produced an error %s but expected nil", err.Error())
+	}
+	expected := EdgeData{"3", "1", founded}
+	if len(edges) != 1 || edges[0] != expected {
+		t.Errorf("Connections() produced %v but expected [%v]", edges, expected)
+	}
+
+	// the package-level functions still work against the same file
+	node, err = FindNode("1", file)
+	if node != apple || err != nil {
+		t.Errorf("FindNode() produced %q,%v but expected %q,nil", node, err, apple)
+	}
+
+	_, err = NewGraph()
+	if !ErrorMatches(err, "invalid database file reference") {
+		t.Errorf("NewGraph() produced %v but expected %q", err, "invalid database file reference")
+	}
+}
+
+func TestAddNodeBodies(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	count, err := AddNode("8", []byte(`{}`), file)
+	if count != 1 || err != nil {
+		t.Errorf("AddNode() inserted %d,%v but expected 1,nil", count, err)
+	}
+	node, err := FindNode("8", file)
+	if node != `{"id":"8"}` || err != nil {
+		t.Errorf("FindNode() produced %q,%v but expected %q,nil", node, err, `{"id":"8"}`)
+	}
+
+	count, err = AddNode("9", []byte(`null`), file)
+	if count != 0 || !ErrorMatches(err, "node body is not a JSON object") {
+		t.Errorf("AddNode() inserted %d,%v but expected 0 and an error", count, err)
+	}
+
+	count, err = AddNodes([]string{"10", "11"}, [][]byte{[]byte("{\"name\":\"x\"}\n"), []byte(`null`)}, file)
+	if count != 0 || !ErrorMatches(err, "node 1: node body is not a JSON object") {
+		t.Errorf("AddNodes() inserted %d,%v but expected 0 and an error", count, err)
+	}
+}
+
+func TestAddNodeReturningRowID(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	AddNodes([]string{"1", "2"}, [][]byte{[]byte(apple), []byte(woz)}, file)
+	rowid, err := AddNodeReturningRowID("3", []byte(jobs), file)
+	if rowid != 3 || err != nil {
+		t.Errorf("AddNodeReturningRowID() produced %d,%v but expected 3,nil", rowid, err)
+	}
+	rowid, err = AddNodeReturningRowID("4", []byte(wayne), file)
+	if rowid != 4 || err != nil {
+		t.Errorf("AddNodeReturningRowID() produced %d,%v but expected 4,nil", rowid, err)
+	}
+	node, _ := FindNode("4", file)
+	if node != `{"id":"4","name":"Ronald Wayne","type":["person","administrator","founder"]}` {
+		t.Errorf("FindNode() produced %q but expected the id to be added as AddNode() does", node)
+	}
+
+	rowid, err = AddNodeReturningRowID("3", []byte(jobs), file)
+	if rowid != 0 || !ErrorMatches(err, UNIQUE_ID_CONSTRAINT) {
+		t.Errorf("AddNodeReturningRowID() produced %d,%v but expected 0,%q", rowid, err, UNIQUE_ID_CONSTRAINT)
+	}
+}
+
+func TestAddNodeAndId(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	for identifier, test := range map[string]struct {
+		node     string
+		expected string
+	}{
+		"1": {"{\n  \"name\": \"Apple}\",\n  \"meta\": {\"founded\": 1976}\n}\n", `{"id":"1","meta":{"founded":1976},"name":"Apple}"}`},
+		"2": {`{"id":"woz","name":"Steve Wozniak"}`, `{"id":"2","name":"Steve Wozniak"}`},
+	} {
+		count, err := AddNodeAndId([]byte(test.node), identifier, file)
+		if count != 1 || err != nil {
+			t.Errorf("AddNodeAndId() inserted %d,%v but expected 1,nil", count, err)
+		}
+		node, err := FindNode(identifier, file)
+		if node != test.expected || err != nil {
+			t.Errorf("FindNode() produced %q,%v but expected %q,nil", node, err, test.expected)
+		}
+	}
+
+	for _, node := range []string{`{"name":"x"`, `["x"]`} {
+		count, err := AddNodeAndId([]byte(node), "3", file)
+		if count != 0 || err == nil {
+			t.Errorf("AddNodeAndId(%q) inserted %d,%v but expected 0 and an error", node, count, err)
+		}
+	}
+}
+
+func TestNodeValidator(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	missingType := errors.New("node has no type")
+	hasType := func(body []byte) error {
+		var node map[string]json.RawMessage
+		if json.Unmarshal(body, &node) != nil || node["type"] == nil {
+			return missingType
+		}
+		return nil
+	}
+	graph, _ := NewGraphWithOptions([]Option{WithNodeValidator(hasType)}, file)
+	defer graph.Close()
+
+	untyped := []byte(`{"name":"Steve Wozniak"}`)
+	for _, test := range []struct {
+		name  string
+		write func() (int64, error)
+	}{
+		{"AddNode", func() (int64, error) { return graph.AddNode("2", untyped) }},
+		{"AddNodes", func() (int64, error) { return graph.AddNodes([]string{"1", "2"}, [][]byte{[]byte(apple), untyped}) }},
+		{"UpsertNode", func() (int64, error) { return graph.UpsertNode("2", untyped) }},
+		{"UpdateNode", func() (int64, error) { return graph.UpdateNode("1", untyped) }},
+		{"PatchNode", func() (int64, error) { return graph.PatchNode("1", []byte(`{"type":null}`)) }},
+		{"UpdateNodesWhere", func() (int64, error) {
+			return graph.UpdateNodesWhere("id = ?", []interface{}{"1"}, []byte(`{"type":null}`))
+		}},
+	} {
+		if test.name == "UpdateNode" {
+			graph.AddNode("1", []byte(apple))
+		}
+		count, err := test.write()
+		if count != 0 || !errors.Is(err, missingType) {
+			t.Errorf("%s() produced %d,%v but expected 0,%v", test.name, count, err, missingType)
+		}
+	}
+	err := graph.UpdateNodeBody("1", string(untyped))
+	if !errors.Is(err, missingType) {
+		t.Errorf("UpdateNodeBody() produced %v but expected %v", err, missingType)
+	}
+
+	node, _ := graph.FindNode("1")
+	if node != apple {
+		t.Errorf("FindNode() produced %q but expected the rejected writes to leave %q", node, apple)
+	}
+	count, _ := graph.CountNodes()
+	if count != 1 {
+		t.Errorf("CountNodes() produced %d but expected 1", count)
+	}
+
+	_, err = graph.AddNode("2", []byte(woz))
+	if err != nil {
+		t.Errorf("AddNode() with a type produced an error %q but expected nil", err.Error())
+	}
+	_, err = graph.AddNode("3", []byte(`{"name":`))
+	if !ErrorMatches(err, INVALID_NODE_JSON) {
+		t.Errorf("AddNode() produced %v but expected %q before the validator ran", err, INVALID_NODE_JSON)
+	}
+}
+
+func TestCanonicalJSON(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	graph, err := NewGraphWithOptions([]Option{WithCanonicalJSON()}, file)
+	if err != nil {
+		t.Fatalf("NewGraphWithOptions() produced an error %q but expected nil", err.Error())
+	}
+	defer graph.Close()
+
+	expected := `{"founded":{"day":1,"month":"April","year":1976},"id":"1","name":"Apple <Computer> & Co","value":1.50}`
+	bodies := []string{
+		`{"name":"Apple <Computer> & Co","founded":{"year":1976,"month":"April","day":1},"value":1.50}`,
+		"{\n  \"value\": 1.50,\n  \"id\": \"1\",\n  \"founded\": {\"day\": 1, \"month\": \"April\", \"year\": 1976},\n  \"name\": \"Apple <Computer> & Co\"\n}",
+	}
+	writes := []struct {
+		name  string
+		write func(body []byte) error
+	}{
+		{"AddNode", func(body []byte) error { _, err := graph.AddNode("1", body); return err }},
+		{"AddNodes", func(body []byte) error { _, err := graph.AddNodes([]string{"1"}, [][]byte{body}); return err }},
+		{"AddNodeAndId", func(body []byte) error { _, err := graph.AddNodeAndId(body, "1"); return err }},
+		{"UpdateNode", func(body []byte) error { _, err := graph.UpdateNode("1", body); return err }},
+		{"UpsertNode", func(body []byte) error { _, err := graph.UpsertNode("1", body); return err }},
+	}
+	for _, w := range writes {
+		for _, body := range bodies {
+			if w.name == "UpdateNode" {
+				graph.AddNode("1", []byte(`{}`))
+			}
+			err := w.write([]byte(body))
+			node, findErr := graph.FindNode("1")
+			if node != expected || err != nil || findErr != nil {
+				t.Errorf("%s() stored %q,%v but expected %q,nil", w.name, node, err, expected)
+			}
+			graph.RemoveNodes([]string{"1"})
+		}
+	}
+
+	// without the option bodies are stored as given, just compacted
+	AddNode("2", []byte(`{"name":"b", "id":"2", "a":[1, 2]}`), file)
+	node, _ := FindNode("2", file)
+	if node != `{"name":"b","id":"2","a":[1,2]}` {
+		t.Errorf("FindNode() produced %q but expected the members in their original order", node)
+	}
+}
+
+func TestInvalidJSON(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	malformed := []byte(`{"name":"Steve Jobs",}`)
+
+	count, err := AddNode("3", malformed, file)
+	if count != 0 || !ErrorMatches(err, INVALID_NODE_JSON) {
+		t.Errorf("AddNode() inserted %d,%v but expected 0,%q", count, err, INVALID_NODE_JSON)
+	}
+
+	count, err = AddNodes([]string{"1", "3"}, [][]byte{[]byte(apple), malformed}, file)
+	expected := "node 1: " + INVALID_NODE_JSON
+	if count != 0 || !ErrorMatches(err, expected) {
+		t.Errorf("AddNodes() inserted %d,%v but expected 0,%q", count, err, expected)
+	}
+
+	count, err = AddNodes([]string{"1", "3"}, [][]byte{[]byte(apple), []byte(jobs)}, file)
+	if count != 2 || err != nil {
+		t.Errorf("AddNodes() inserted %d,%v but expected 2,nil", count, err)
+	}
+
+	count, err = UpdateNode("3", malformed, file)
+	if count != 0 || !ErrorMatches(err, INVALID_NODE_JSON) {
+		t.Errorf("UpdateNode() updated %d,%v but expected 0,%q", count, err, INVALID_NODE_JSON)
+	}
+
+	count, err = UpsertNode("3", malformed, file)
+	if count != 0 || !ErrorMatches(err, INVALID_NODE_JSON) {
+		t.Errorf("UpsertNode() upserted %d,%v but expected 0,%q", count, err, INVALID_NODE_JSON)
+	}
+
+	count, err = ConnectNodesWithProperties("3", "1", []byte(`{"action":founded}`), file)
+	if count != 0 || !ErrorMatches(err, INVALID_EDGE_JSON) {
+		t.Errorf("ConnectNodesWithProperties() inserted %d,%v but expected 0,%q", count, err, INVALID_EDGE_JSON)
+	}
+
+	count, err = ConnectNodesBatch([]EdgeData{{"3", "1", founded}, {"3", "1", `{`}}, file)
+	expected = "edge 1: " + INVALID_EDGE_JSON
+	if count != 0 || !ErrorMatches(err, expected) {
+		t.Errorf("ConnectNodesBatch() inserted %d,%v but expected 0,%q", count, err, expected)
+	}
+
+	count, err = ConnectNodesWithProperties("3", "1", []byte(founded), file)
+	if count != 1 || err != nil {
+		t.Errorf("ConnectNodesWithProperties() inserted %d,%v but expected 1,nil", count, err)
+	}
+}
+
+func TestListNodes(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	AddNodes([]string{"1", "2", "3"}, [][]byte{[]byte(apple), []byte(woz), []byte(jobs)}, file)
+
+	for _, test := range []struct {
+		limit    int
+		offset   int
+		expected []string
+	}{
+		{0, 0, []string{apple, woz, jobs}},
+		{-1, 1, []string{woz, jobs}},
+		{2, 0, []string{apple, woz}},
+		{2, 2, []string{jobs}},
+		{2, 3, []string{}},
+	} {
+		nodes, err := ListNodes(test.limit, test.offset, file)
+		if err != nil || nodes == nil || fmt.Sprint(nodes) != fmt.Sprint(test.expected) {
+			t.Errorf("ListNodes(%d, %d) produced %v,%v but expected %v,nil", test.limit, test.offset, nodes, err, test.expected)
+		}
+	}
+}
+
+func TestClear(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	AddNodes([]string{"1", "2", "3"}, [][]byte{[]byte(apple), []byte(woz), []byte(jobs)}, file)
+	ConnectNodesWithProperties("2", "1", []byte(founded), file)
+	ConnectNodesWithProperties("3", "1", []byte(founded), file)
+
+	err := Clear(file)
+	if err != nil {
+		t.Fatalf("Clear() produced an error %q but expected nil", err.Error())
+	}
+	nodes, _ := CountNodes(file)
+	edges, _ := CountEdges(file)
+	if nodes != 0 || edges != 0 {
+		t.Errorf("Clear() left %d nodes and %d edges but expected none", nodes, edges)
+	}
+
+	// the schema is still there, so the graph can be used straight away
+	version, _ := SchemaVersion(file)
+	if version != LatestSchemaVersion() {
+		t.Errorf("SchemaVersion() after Clear() produced %d but expected %d", version, LatestSchemaVersion())
+	}
+	count, err := AddNode("1", []byte(apple), file)
+	if count != 1 || err != nil {
+		t.Errorf("AddNode() after Clear() produced %d,%v but expected 1,nil", count, err)
+	}
+
+	graph, _ := OpenReadOnly(file)
+	defer graph.Close()
+	err = graph.Clear()
+	if !ErrorMatches(err, READ_ONLY) {
+		t.Errorf("Clear() on a read-only graph produced %v but expected %q", err, READ_ONLY)
+	}
+}
+
+func TestIterateNodes(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	AddNodes([]string{"1", "2", "3"}, [][]byte{[]byte(apple), []byte(woz), []byte(jobs)}, file)
+
+	visited := []string{}
+	err := IterateNodes(func(body string) error {
+		visited = append(visited, body)
+		return nil
+	}, file)
+	expected := []string{apple, woz, jobs}
+	if fmt.Sprint(visited) != fmt.Sprint(expected) || err != nil {
+		t.Errorf("IterateNodes() visited %v,%v but expected %v,nil", visited, err, expected)
+	}
+
+	stop := errors.New("stop")
+	visited = []string{}
+	err = IterateNodes(func(body string) error {
+		visited = append(visited, body)
+		if len(visited) == 2 {
+			return stop
+		}
+		return nil
+	}, file)
+	if len(visited) != 2 || err != stop {
+		t.Errorf("IterateNodes() visited %d,%v but expected 2,%v", len(visited), err, stop)
+	}
+}
+
+func TestIterateEdgesFrom(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	AddNodes([]string{"1", "2", "3", "4"}, [][]byte{[]byte(apple), []byte(woz), []byte(jobs), []byte(wayne)}, file)
+	ConnectNodesWithProperties("2", "1", []byte(founded), file)
+	ConnectNodesWithProperties("3", "1", []byte(founded), file)
+	ConnectNodes("2", "3", file)
+	ConnectNodesWithProperties("4", "1", []byte(divested), file)
+
+	visited := []EdgeData{}
+	err := IterateEdgesFrom([]string{"3", "2", "1"}, func(edge EdgeData) error {
+		visited = append(visited, edge)
+		return nil
+	}, file)
+	expected := []EdgeData{{"2", "1", founded}, {"2", "3", "{}"}, {"3", "1", founded}}
+	if fmt.Sprint(visited) != fmt.Sprint(expected) || err != nil {
+		t.Errorf("IterateEdgesFrom() visited %v,%v but expected %v,nil", visited, err, expected)
+	}
+
+	stop := errors.New("stop")
+	count := 0
+	err = IterateEdgesFrom([]string{"2", "3", "4"}, func(edge EdgeData) error {
+		count++
+		return stop
+	}, file)
+	if count != 1 || err != stop {
+		t.Errorf("IterateEdgesFrom() visited %d,%v but expected 1,%v", count, err, stop)
+	}
+
+	err = IterateEdgesFrom([]string{}, func(edge EdgeData) error {
+		return stop
+	}, file)
+	if err != nil {
+		t.Errorf("IterateEdgesFrom() with no sources produced %v but expected nil", err)
+	}
+
+	graph, _ := NewGraphWithOptions([]Option{WithUndirectedEdges()}, file)
+	defer graph.Close()
+	count = 0
+	err = graph.IterateEdgesFrom([]string{"1"}, func(edge EdgeData) error {
+		count++
+		return nil
+	})
+	if count != 3 || err != nil {
+		t.Errorf("IterateEdgesFrom() on an undirected graph visited %d,%v but expected 3,nil", count, err)
+	}
+
+	// hold the one connection of an in-memory graph to the 999 variables
+	// older SQLite builds allow
+	memory, _ := NewGraphWithOptions([]Option{WithUndirectedEdges()}, IN_MEMORY)
+	defer memory.Close()
+	memory.Initialize()
+	conn, _ := memory.db.Conn(context.Background())
+	conn.Raw(func(driverConn interface{}) error {
+		driverConn.(*pooledConn).SetLimit(sqlite3.SQLITE_LIMIT_VARIABLE_NUMBER, 999)
+		return nil
+	})
+	conn.Close()
+	identifiers, nodes := makeBenchmarkNodes(MAX_IDS_PER_QUERY + 10)
+	memory.AddNodes(identifiers, nodes)
+	memory.ConnectNodes(identifiers[len(identifiers)-1], identifiers[len(identifiers)-2])
+	count = 0
+	err = memory.IterateEdgesFrom(identifiers, func(edge EdgeData) error {
+		count++
+		return nil
+	})
+	if count != 1 || err != nil {
+		t.Errorf("IterateEdgesFrom() across undirected chunks visited %d,%v but expected 1,nil", count, err)
+	}
+
+	// the one connection is free again by the time fn runs
+	targets := []string{}
+	err = memory.IterateEdgesFrom(identifiers[len(identifiers)-2:], func(edge EdgeData) error {
+		body, err := memory.FindNode(edge.Target)
+		targets = append(targets, body)
+		return err
+	})
+	if len(targets) != 1 || err != nil {
+		t.Errorf("IterateEdgesFrom() with fn reading the graph produced %v,%v but expected one body,nil", targets, err)
+	}
+}
+
+func TestListEdges(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	AddNodes([]string{"1", "2", "3"}, [][]byte{[]byte(apple), []byte(woz), []byte(jobs)}, file)
+	ConnectNodesWithProperties("2", "1", []byte(founded), file)
+	ConnectNodesWithProperties("3", "1", []byte(founded), file)
+	ConnectNodes("2", "3", file)
+
+	all := []EdgeData{{"2", "1", founded}, {"3", "1", founded}, {"2", "3", `{}`}}
+	for _, test := range []struct {
+		limit    int
+		offset   int
+		expected []EdgeData
+	}{
+		{0, 0, all},
+		{2, 0, all[:2]},
+		{2, 2, all[2:]},
+		{1, 5, []EdgeData{}},
+	} {
+		edges, err := ListEdges(test.limit, test.offset, file)
+		if err != nil || edges == nil || fmt.Sprint(edges) != fmt.Sprint(test.expected) {
+			t.Errorf("ListEdges(%d, %d) produced %v,%v but expected %v,nil", test.limit, test.offset, edges, err, test.expected)
+		}
+	}
+}
+
+func TestFindOrCreateNode(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+	AddNode("1", []byte(apple), file)
+
+	body, created, err := FindOrCreateNode("1", []byte(`{"name":"someone else"}`), file)
+	if body != apple || created || err != nil {
+		t.Errorf("FindOrCreateNode() on an existing node produced %q,%t,%v but expected %q,false,nil", body, created, err, apple)
+	}
+
+	expected := `{"id":"2","name":"Steve Wozniak"}`
+	body, created, err = FindOrCreateNode("2", []byte(`{"name":"Steve Wozniak","id":"8"}`), file)
+	if body != expected || !created || err != nil {
+		t.Errorf("FindOrCreateNode() on a new node produced %q,%t,%v but expected %q,true,nil", body, created, err, expected)
+	}
+
+	_, _, err = FindOrCreateNode("3", []byte(`{"name":`), file)
+	if !ErrorMatches(err, INVALID_NODE_JSON) {
+		t.Errorf("FindOrCreateNode() produced %v but expected %q", err, INVALID_NODE_JSON)
+	}
+
+	graph, _ := NewGraph(file)
+	defer graph.Close()
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	creations := 0
+	for w := 0; w < 20; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, created, err := graph.FindOrCreateNode("4", []byte(wayne))
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				t.Errorf("concurrent FindOrCreateNode() failed: %v", err)
+			}
+			if created {
+				creations++
+			}
+		}()
+	}
+	wg.Wait()
+	if creations != 1 {
+		t.Errorf("concurrent FindOrCreateNode() created the node %d times but expected once", creations)
+	}
+}
+
+func TestEnsureEdge(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+	AddNode("1", []byte(apple), file)
+
+	err := EnsureEdge("2", []byte(`{"name":"Steve Wozniak"}`), "1", []byte(`{"name":"not used"}`), []byte(founded), file)
+	if err != nil {
+		t.Fatalf("EnsureEdge() produced an error %q but expected nil", err.Error())
+	}
+	err = EnsureEdge("3", nil, "2", nil, nil, file)
+	if err != nil {
+		t.Fatalf("EnsureEdge() with stubs produced an error %q but expected nil", err.Error())
+	}
+
+	nodes, _ := FindNodesByIds([]string{"1", "2", "3"}, file)
+	expected := map[string]string{"1": apple, "2": `{"id":"2","name":"Steve Wozniak"}`, "3": `{"id":"3"}`}
+	if fmt.Sprint(nodes) != fmt.Sprint(expected) {
+		t.Errorf("FindNodesByIds() after EnsureEdge() produced %v but expected %v", nodes, expected)
+	}
+	edges, _ := ListEdges(0, 0, file)
+	expectedEdges := []EdgeData{{"2", "1", founded}, {"3", "2", "{}"}}
+	if fmt.Sprint(edges) != fmt.Sprint(expectedEdges) {
+		t.Errorf("ListEdges() after EnsureEdge() produced %v but expected %v", edges, expectedEdges)
+	}
+
+	// a bad edge rolls back the nodes made for it
+	err = EnsureEdge("4", []byte(wayne), "1", nil, []byte(`{"since":`), file)
+	if !ErrorMatches(err, INVALID_EDGE_JSON) {
+		t.Errorf("EnsureEdge() produced %v but expected %q", err, INVALID_EDGE_JSON)
+	}
+	exists, _ := NodeExists("4", file)
+	if exists {
+		t.Errorf("EnsureEdge() left node 4 behind after failing")
+	}
+}
+
+func TestConnectNodesMissingEnds(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+	AddNode("1", []byte(apple), file)
+
+	for _, test := range []struct {
+		opts     []Option
+		source   string
+		target   string
+		expected string
+	}{
+		{nil, "x", "1", `source node "x" does not exist`},
+		{nil, "1", "y", `target node "y" does not exist`},
+		{nil, "x", "y", `source node "x" does not exist`},
+		{[]Option{WithForeignKeys(false)}, "1", "y", `target node "y" does not exist`},
+	} {
+		graph, _ := NewGraphWithOptions(test.opts, file)
+		count, err := graph.ConnectNodesWithProperties(test.source, test.target, []byte(founded))
+		if count != 0 || !ErrorMatches(err, test.expected) || !errors.Is(err, ErrNodeNotFound) {
+			t.Errorf("ConnectNodesWithProperties(%q, %q) produced %d,%v but expected 0,%q", test.source, test.target, count, err, test.expected)
+		}
+		graph.Close()
+	}
+	edges, _ := CountEdges(file)
+	if edges != 0 {
+		t.Errorf("ConnectNodesWithProperties() left %d dangling edges but expected none", edges)
+	}
+
+	// the foreign keys are still on underneath as a backstop
+	graph, _ := NewGraph(file)
+	defer graph.Close()
+	enforced, err := graph.queryCount("PRAGMA foreign_keys")
+	if enforced != 1 || err != nil {
+		t.Errorf("PRAGMA foreign_keys produced %d,%v but expected 1,nil", enforced, err)
+	}
+}
+
+func TestConnectNodesUnique(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	AddNodes([]string{"1", "4"}, [][]byte{[]byte(apple), []byte(wayne)}, file)
+
+	count, err := ConnectNodesUnique("4", "1", []byte(founded), file)
+	if count != 1 || err != nil {
+		t.Errorf("ConnectNodesUnique() inserted %d,%v but expected 1,nil", count, err)
+	}
+	for _, properties := range []string{founded, divested} {
+		count, err = ConnectNodesUnique("4", "1", []byte(properties), file)
+		if count != 0 || err != nil {
+			t.Errorf("ConnectNodesUnique() inserted %d,%v but expected 0,nil", count, err)
+		}
+	}
+	count, err = ConnectNodesUnique("1", "4", []byte(founded), file)
+	if count != 1 || err != nil {
+		t.Errorf("ConnectNodesUnique() inserted %d,%v but expected 1,nil", count, err)
+	}
+
+	edges, _ := CountEdges(file)
+	if edges != 2 {
+		t.Errorf("CountEdges() produced %d but expected 2", edges)
+	}
+}
+
+func TestGetEdgeProperties(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	AddNodes([]string{"1", "4"}, [][]byte{[]byte(apple), []byte(wayne)}, file)
+	ConnectNodesWithProperties("4", "1", []byte(founded), file)
+	ConnectNodesWithProperties("4", "1", []byte(divested), file)
+
+	properties, err := GetEdgeProperties("4", "1", file)
+	if len(properties) != 2 || !arrayContains(properties, founded) || !arrayContains(properties, divested) || err != nil {
+		t.Errorf("GetEdgeProperties() produced %v,%v but expected %v,nil", properties, err, []string{founded, divested})
+	}
+
+	properties, err = GetEdgeProperties("1", "4", file)
+	if properties == nil || len(properties) != 0 || err != nil {
+		t.Errorf("GetEdgeProperties() produced %v,%v but expected [],nil", properties, err)
+	}
+}
+
+func TestGetEdgesBetween(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	AddNodes([]string{"1", "2", "4"}, [][]byte{[]byte(apple), []byte(woz), []byte(wayne)}, file)
+	ConnectNodesWithProperties("4", "1", []byte(founded), file)
+	ConnectNodesWithProperties("1", "4", []byte(invested), file)
+	ConnectNodesWithProperties("4", "1", []byte(divested), file)
+	ConnectNodesWithProperties("2", "1", []byte(founded), file)
+
+	for _, test := range []struct {
+		name     string
+		find     func(string, string, ...string) ([]EdgeData, error)
+		expected []EdgeData
+	}{
+		{"GetEdgesBetween", GetEdgesBetween, []EdgeData{{"4", "1", founded}, {"4", "1", divested}}},
+		{"GetEdgesBetweenUndirected", GetEdgesBetweenUndirected, []EdgeData{{"4", "1", founded}, {"1", "4", invested}, {"4", "1", divested}}},
+	} {
+		edges, err := test.find("4", "1", file)
+		if len(edges) != len(test.expected) || err != nil {
+			t.Errorf("%s() produced %v,%v but expected %v,nil", test.name, edges, err, test.expected)
+			continue
+		}
+		for i, edge := range test.expected {
+			if edges[i] != edge {
+				t.Errorf("%s() produced %v but expected %v", test.name, edges, test.expected)
+			}
+		}
+	}
+
+	edges, err := GetEdgesBetween("2", "4", file)
+	if edges == nil || len(edges) != 0 || err != nil {
+		t.Errorf("GetEdgesBetween() produced %v,%v but expected [],nil", edges, err)
+	}
+}
+
+func TestUndirectedEdges(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+	graph, _ := NewGraphWithOptions([]Option{WithUndirectedEdges()}, file)
+	defer graph.Close()
+
+	graph.AddNodes([]string{"1", "2", "3", "4"}, [][]byte{[]byte(apple), []byte(woz), []byte(jobs), []byte(wayne)})
+	for _, pair := range [][2]string{{"2", "1"}, {"1", "2"}, {"3", "1"}} {
+		graph.ConnectNodes(pair[0], pair[1])
+	}
+	count, err := graph.BulkConnectNodes([]string{"1", "4", "4"}, []string{"3", "1", "1"})
+	if count != 1 || err != nil {
+		t.Errorf("BulkConnectNodes() produced %d,%v but expected 1,nil", count, err)
+	}
+
+	// one edge per pair, stored with the lesser id as its source
+	edges, _ := graph.ListEdges(0, 0)
+	expected := []EdgeData{{"1", "2", "{}"}, {"1", "3", "{}"}, {"1", "4", "{}"}}
+	if len(edges) != len(expected) {
+		t.Fatalf("ListEdges() produced %v but expected %v", edges, expected)
+	}
+	for i, edge := range expected {
+		if edges[i] != edge {
+			t.Errorf("ListEdges() produced %v but expected %v", edges, expected)
+		}
+	}
+
+	for _, test := range []struct {
+		name  string
+		count func(string) (int, error)
+	}{
+		{"InDegree", graph.InDegree},
+		{"OutDegree", graph.OutDegree},
+		{"Degree", graph.Degree},
+	} {
+		degree, err := test.count("1")
+		if degree != 3 || err != nil {
+			t.Errorf("%s() produced %d,%v but expected 3,nil", test.name, degree, err)
+		}
+	}
+	incoming, _ := graph.GetIncoming("1")
+	outgoing, _ := graph.GetOutgoing("1")
+	if len(incoming) != 3 || len(outgoing) != 3 {
+		t.Errorf("GetIncoming() and GetOutgoing() produced %v and %v but expected all 3 edges", incoming, outgoing)
+	}
+
+	between, err := graph.GetEdgesBetween("3", "1")
+	if len(between) != 1 || between[0] != (EdgeData{"1", "3", "{}"}) || err != nil {
+		t.Errorf("GetEdgesBetween() produced %v,%v but expected the edge from 1 to 3", between, err)
+	}
+	updated, err := graph.UpdateEdgeProperties("3", "1", []byte(founded))
+	if updated != 1 || err != nil {
+		t.Errorf("UpdateEdgeProperties() produced %d,%v but expected 1,nil", updated, err)
+	}
+
+	path, err := graph.ShortestPath("2", "4")
+	if !pathMatches(path, []string{"2", "1", "4"}) || err != nil {
+		t.Errorf("ShortestPath() produced %v,%v but expected [2 1 4],nil", path, err)
+	}
+	visited, err := graph.TraverseFiltered("3", func(props string) bool { return props == founded }, 0)
+	if !pathMatches(visited, []string{"3", "1"}) || err != nil {
+		t.Errorf("TraverseFiltered() produced %v,%v but expected [3 1],nil", visited, err)
+	}
+	paths, err := graph.AllPaths("4", "2", 3)
+	if len(paths) != 1 || !pathMatches(paths[0], []string{"4", "1", "2"}) || err != nil {
+		t.Errorf("AllPaths() produced %v,%v but expected [[4 1 2]],nil", paths, err)
+	}
+
+	removed, err := graph.RemoveEdge("2", "1")
+	if removed != 1 || err != nil {
+		t.Errorf("RemoveEdge() produced %d,%v but expected 1,nil", removed, err)
+	}
+}
+
+func TestUpdateEdgeProperties(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	AddNodes([]string{"1", "2", "4"}, [][]byte{[]byte(apple), []byte(woz), []byte(wayne)}, file)
+	ConnectNodesWithProperties("4", "1", []byte(founded), file)
+	ConnectNodesWithProperties("4", "1", []byte(divested), file)
+	ConnectNodesWithProperties("2", "1", []byte(founded), file)
+
+	invested := `{"action":"invested"}`
+	count, err := UpdateEdgePropertiesMatching("4", "1", []byte(divested), []byte(invested), file)
+	if count != 1 || err != nil {
+		t.Errorf("UpdateEdgePropertiesMatching() updated %d,%v but expected 1,nil", count, err)
+	}
+	properties, _ := GetEdgeProperties("4", "1", file)
+	if len(properties) != 2 || !arrayContains(properties, founded) || !arrayContains(properties, invested) {
+		t.Errorf("GetEdgeProperties() produced %v but expected %v", properties, []string{founded, invested})
+	}
+
+	count, err = UpdateEdgePropertiesMatching("4", "1", []byte(divested), []byte(invested), file)
+	if count != 0 || err != nil {
+		t.Errorf("UpdateEdgePropertiesMatching() updated %d,%v but expected 0,nil", count, err)
+	}
+
+	count, err = UpdateEdgeProperties("4", "1", []byte(`{}`), file)
+	if count != 2 || err != nil {
+		t.Errorf("UpdateEdgeProperties() updated %d,%v but expected 2,nil", count, err)
+	}
+	properties, _ = GetEdgeProperties("2", "1", file)
+	if len(properties) != 1 || properties[0] != founded {
+		t.Errorf("UpdateEdgeProperties() changed other edges: %v", properties)
+	}
+
+	count, err = UpdateEdgeProperties("4", "1", []byte(`{"action":`), file)
+	if count != 0 || !ErrorMatches(err, INVALID_EDGE_JSON) {
+		t.Errorf("UpdateEdgeProperties() updated %d,%v but expected 0,%q", count, err, INVALID_EDGE_JSON)
+	}
+}
+
+func TestDegree(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	AddNodes([]string{"1", "2", "3", "4"}, [][]byte{[]byte(apple), []byte(woz), []byte(jobs), []byte(wayne)}, file)
+	ConnectNodesWithProperties("2", "1", []byte(founded), file)
+	ConnectNodesWithProperties("3", "1", []byte(founded), file)
+	ConnectNodesWithProperties("4", "1", []byte(founded), file)
+	ConnectNodesWithProperties("4", "1", []byte(divested), file)
+	ConnectNodes("1", "2", file)
+
+	for _, test := range []struct {
+		identifier string
+		in         int
+		out        int
+	}{
+		{"1", 4, 1},
+		{"2", 1, 1},
+		{"4", 0, 2},
+		{"5", 0, 0},
+	} {
+		in, err := InDegree(test.identifier, file)
+		if in != test.in || err != nil {
+			t.Errorf("InDegree(%q) produced %d,%v but expected %d,nil", test.identifier, in, err, test.in)
+		}
+		out, err := OutDegree(test.identifier, file)
+		if out != test.out || err != nil {
+			t.Errorf("OutDegree(%q) produced %d,%v but expected %d,nil", test.identifier, out, err, test.out)
+		}
+		degree, err := Degree(test.identifier, file)
+		if degree != test.in+test.out || err != nil {
+			t.Errorf("Degree(%q) produced %d,%v but expected %d,nil", test.identifier, degree, err, test.in+test.out)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	stats, err := Stats(file)
+	if stats != (GraphStats{}) || err != nil {
+		t.Errorf("Stats() on an empty graph produced %+v,%v but expected zero values", stats, err)
+	}
+
+	AddNodes([]string{"1", "2", "3", "4", "5"},
+		[][]byte{[]byte(apple), []byte(woz), []byte(jobs), []byte(wayne), []byte(markkula)}, file)
+	ConnectNodesWithProperties("2", "1", []byte(founded), file)
+	ConnectNodesWithProperties("3", "1", []byte(founded), file)
+	ConnectNodesWithProperties("4", "1", []byte(founded), file)
+	ConnectNodesWithProperties("4", "1", []byte(divested), file)
+
+	expected := GraphStats{Nodes: 5, Edges: 4, Orphans: 1, MaxDegree: 4, AverageDegree: 1.6}
+	stats, err = Stats(file)
+	if stats != expected || err != nil {
+		t.Errorf("Stats() produced %+v,%v but expected %+v,nil", stats, err, expected)
+	}
+}
+
+func TestGetNodeWithEdges(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	AddNodes([]string{"1", "2", "3", "4"}, [][]byte{[]byte(apple), []byte(woz), []byte(jobs), []byte(wayne)}, file)
+	ConnectNodesWithProperties("2", "1", []byte(founded), file)
+	ConnectNodesWithProperties("3", "1", []byte(founded), file)
+	ConnectNodesWithProperties("1", "4", []byte(divested), file)
+
+	body, incoming, outgoing, err := GetNodeWithEdges("1", file)
+	if body != apple || err != nil {
+		t.Errorf("GetNodeWithEdges() produced %q,%v but expected %q,nil", body, err, apple)
+	}
+	expectedIn := []EdgeData{{"2", "1", founded}, {"3", "1", founded}}
+	if len(incoming) != len(expectedIn) || incoming[0] != expectedIn[0] || incoming[1] != expectedIn[1] {
+		t.Errorf("GetNodeWithEdges() produced incoming %v but expected %v", incoming, expectedIn)
+	}
+	expectedOut := []EdgeData{{"1", "4", divested}}
+	if len(outgoing) != len(expectedOut) || outgoing[0] != expectedOut[0] {
+		t.Errorf("GetNodeWithEdges() produced outgoing %v but expected %v", outgoing, expectedOut)
+	}
+
+	stored, _ := FindNode("4", file)
+	body, incoming, outgoing, err = GetNodeWithEdges("4", file)
+	if body != stored || len(incoming) != 1 || len(outgoing) != 0 || err != nil {
+		t.Errorf("GetNodeWithEdges() produced %q,%v,%v,%v but expected one incoming edge only", body, incoming, outgoing, err)
+	}
+
+	body, incoming, outgoing, err = GetNodeWithEdges("5", file)
+	if body != "" || incoming != nil || outgoing != nil || !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("GetNodeWithEdges() produced %q,%v,%v,%v but expected %v", body, incoming, outgoing, err, ErrNodeNotFound)
+	}
+}
+
+func TestGetNodeWithEdgesUndirected(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	graph, _ := NewGraphWithOptions([]Option{WithUndirectedEdges()}, file)
+	defer graph.Close()
+	graph.AddNodes([]string{"1", "2", "3"}, [][]byte{[]byte(apple), []byte(woz), []byte(jobs)})
+	graph.ConnectNodesWithProperties("2", "1", []byte(founded))
+	graph.ConnectNodes("2", "3")
+
+	// edges are stored with the lesser id first, so 2 is the target of one
+	// and the source of the other, and either way both belong to it
+	expected := []EdgeData{{"1", "2", founded}, {"2", "3", `{}`}}
+	_, incoming, outgoing, err := graph.GetNodeWithEdges("2")
+	if fmt.Sprint(incoming) != fmt.Sprint(expected) || fmt.Sprint(outgoing) != fmt.Sprint(expected) || err != nil {
+		t.Errorf("GetNodeWithEdges() on an undirected graph produced %v,%v,%v but expected %v both ways", incoming, outgoing, err, expected)
+	}
+}
+
+func TestErrNodeNotFound(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	_, err := FindNode("7", file)
+	expected := `node "7" not found: ` + NO_ROWS_FOUND
+	if !ErrorMatches(err, expected) {
+		t.Errorf("FindNode() produced %v but expected %q", err, expected)
+	}
+	if !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("FindNode() produced %v which is not ErrNodeNotFound", err)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("FindNode() produced %v which no longer wraps sql.ErrNoRows", err)
+	}
+
+	err = WithTransaction(func(tx *Tx) error {
+		_, err := tx.FindNode("7")
+		return err
+	}, file)
+	if !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("Tx.FindNode() produced %v which is not ErrNodeNotFound", err)
+	}
+	_, err = FindTypedNode[company]("7", file)
+	if !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("FindTypedNode() produced %v which is not ErrNodeNotFound", err)
+	}
+}
+
+func TestFindNodesWhere(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	AddNodes([]string{"1", "2", "3", "4"}, [][]byte{
+		[]byte(`{"name":"Ada","age":36,"city":"London"}`),
+		[]byte(`{"name":"Alan","age":41,"city":"Manchester"}`),
+		[]byte(`{"name":"Grace","age":85,"city":"Arlington"}`),
+		[]byte(`{"name":"Charles","age":79,"city":"London"}`),
+	}, file)
+
+	for _, test := range []struct {
+		where    string
+		args     []interface{}
+		expected []string
+	}{
+		{"json_extract(body, '$.age') > ? AND json_extract(body, '$.city') = ?", []interface{}{40, "London"}, []string{"4"}},
+		{"json_extract(body, '$.age') BETWEEN ? AND ?", []interface{}{30, 80}, []string{"1", "2", "4"}},
+		{"json_extract(body, '$.name') LIKE ? ORDER BY json_extract(body, '$.age') DESC", []interface{}{"A%"}, []string{"2", "1"}},
+		{"json_extract(body, '$.city') = ?", []interface{}{"London' OR '1'='1"}, []string{}},
+	} {
+		bodies, err := FindNodesWhere(test.where, test.args, file)
+		if len(bodies) != len(test.expected) || err != nil {
+			t.Errorf("FindNodesWhere(%q) produced %v,%v but expected ids %v", test.where, bodies, err, test.expected)
+			continue
+		}
+		for i, identifier := range test.expected {
+			if !strings.Contains(bodies[i], `"id":"`+identifier+`"`) {
+				t.Errorf("FindNodesWhere(%q) produced %v but expected ids %v", test.where, bodies, test.expected)
+			}
+		}
+	}
+
+	_, err := FindNodesWhere(" ", nil, file)
+	if !ErrorMatches(err, "empty where clause") {
+		t.Errorf("FindNodesWhere() produced %v but expected an error for an empty clause", err)
+	}
+	_, err = FindNodesWhere("json_extract(body, '$.age') >", nil, file)
+	if err == nil {
+		t.Errorf("FindNodesWhere() produced nil but expected a syntax error")
+	}
+}
+
+func TestFindOrphanNodes(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	orphans, err := FindOrphanNodes(file)
+	if len(orphans) != 0 || err != nil {
+		t.Errorf("FindOrphanNodes() produced %v,%v but expected [],nil", orphans, err)
+	}
+
+	AddNodes([]string{"1", "2", "3", "4", "5"},
+		[][]byte{[]byte(apple), []byte(woz), []byte(jobs), []byte(wayne), []byte(markkula)}, file)
+	ConnectNodesWithProperties("2", "1", []byte(founded), file)
+	ConnectNodes("5", "5", file)
+
+	orphans, err = FindOrphanNodes(file)
+	expected := []string{"3", "4"}
+	if len(orphans) != len(expected) || err != nil {
+		t.Fatalf("FindOrphanNodes() produced %v,%v but expected %v,nil", orphans, err, expected)
+	}
+	for i, id := range expected {
+		if orphans[i] != id {
+			t.Errorf("FindOrphanNodes() produced %v but expected %v", orphans, expected)
+		}
+	}
+}
+
+func TestSelfLoops(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	AddNodes([]string{"1", "2", "5"}, [][]byte{[]byte(apple), []byte(woz), []byte(markkula)}, file)
+	ConnectNodesWithProperties("2", "1", []byte(founded), file)
+	ConnectNodes("5", "5", file)
+	ConnectNodesWithProperties("2", "2", []byte(founded), file)
+
+	loops, err := FindSelfLoops(file)
+	if len(loops) != 2 || err != nil {
+		t.Fatalf("FindSelfLoops() produced %v,%v but expected the loops on 5 and 2", loops, err)
+	}
+	if loops[0].Source != "5" || loops[0].Target != "5" || loops[1].Source != "2" || loops[1].Target != "2" || loops[1].Label != founded {
+		t.Errorf("FindSelfLoops() produced %v but expected the loops on 5 and 2 in insertion order", loops)
+	}
+
+	removed, err := RemoveSelfLoops(file)
+	if removed != 2 || err != nil {
+		t.Errorf("RemoveSelfLoops() produced %d,%v but expected 2,nil", removed, err)
+	}
+	loops, err = FindSelfLoops(file)
+	if len(loops) != 0 || err != nil {
+		t.Errorf("FindSelfLoops() produced %v,%v after RemoveSelfLoops() but expected [],nil", loops, err)
+	}
+	count, err := CountEdges(file)
+	if count != 1 || err != nil {
+		t.Errorf("CountEdges() produced %d,%v but expected the edge from 2 to 1 to remain", count, err)
+	}
+}
+
+func TestFindNodesByIds(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	found, err := FindNodesByIds([]string{}, file)
+	if found == nil || len(found) != 0 || err != nil {
+		t.Errorf("FindNodesByIds() produced %v,%v but expected an empty map,nil", found, err)
+	}
+
+	AddNodes([]string{"1", "2", "3"}, [][]byte{[]byte(apple), []byte(woz), []byte(jobs)}, file)
+	found, err = FindNodesByIds([]string{"3", "1", "99", "1"}, file)
+	if len(found) != 2 || found["1"] != apple || found["3"] != jobs || err != nil {
+		t.Errorf("FindNodesByIds() produced %v,%v but expected the bodies of 1 and 3", found, err)
+	}
+
+	identifiers, nodes := makeBenchmarkNodes(MAX_IDS_PER_QUERY + 10)
+	RemoveNodes([]string{"1", "2", "3"}, file)
+	AddNodes(identifiers, nodes, file)
+	found, err = FindNodesByIds(identifiers, file)
+	if len(found) != len(identifiers) || err != nil {
+		t.Errorf("FindNodesByIds() found %d,%v but expected %d,nil", len(found), err, len(identifiers))
+	}
+}
+
+func TestNodeExists(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	AddNode("1", []byte(apple), file)
+
+	exists, err := NodeExists("1", file)
+	if !exists || err != nil {
+		t.Errorf("NodeExists(\"1\") produced %v,%v but expected true,nil", exists, err)
+	}
+	exists, err = NodeExists("2", file)
+	if exists || err != nil {
+		t.Errorf("NodeExists(\"2\") produced %v,%v but expected false,nil", exists, err)
+	}
+}
+
+func TestNodesExist(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	exists, err := NodesExist([]string{}, file)
+	if exists == nil || len(exists) != 0 || err != nil {
+		t.Errorf("NodesExist() produced %v,%v but expected an empty map,nil", exists, err)
+	}
+
+	AddNodes([]string{"1", "3"}, [][]byte{[]byte(apple), []byte(jobs)}, file)
+	exists, err = NodesExist([]string{"3", "2", "1", "2"}, file)
+	if len(exists) != 3 || !exists["1"] || exists["2"] || !exists["3"] || err != nil {
+		t.Errorf("NodesExist() produced %v,%v but expected 1 and 3 to exist and 2 not to", exists, err)
+	}
+
+	identifiers, nodes := makeBenchmarkNodes(MAX_IDS_PER_QUERY + 10)
+	RemoveNodes([]string{"1", "3"}, file)
+	AddNodes(identifiers[1:], nodes[1:], file)
+	exists, err = NodesExist(identifiers, file)
+	if len(exists) != len(identifiers) || exists[identifiers[0]] || err != nil {
+		t.Fatalf("NodesExist() across chunks produced %d ids,%v but expected %d,nil with the first missing", len(exists), err, len(identifiers))
+	}
+	for _, identifier := range identifiers[1:] {
+		if !exists[identifier] {
+			t.Errorf("NodesExist() reported %q missing across chunks", identifier)
+		}
+	}
+}
+
+func TestCountNodesAndEdges(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	nodes, err := CountNodes(file)
+	if nodes != 0 || err != nil {
+		t.Errorf("CountNodes() produced %d,%v but expected 0,nil", nodes, err)
+	}
+	edges, err := CountEdges(file)
+	if edges != 0 || err != nil {
+		t.Errorf("CountEdges() produced %d,%v but expected 0,nil", edges, err)
+	}
+
+	AddNodes([]string{"1", "2", "3"}, [][]byte{[]byte(apple), []byte(woz), []byte(jobs)}, file)
+	ConnectNodesWithProperties("2", "1", []byte(founded), file)
+	ConnectNodesWithProperties("3", "1", []byte(founded), file)
+
+	nodes, err = CountNodes(file)
+	if nodes != 3 || err != nil {
+		t.Errorf("CountNodes() produced %d,%v but expected 3,nil", nodes, err)
+	}
+	edges, err = CountEdges(file)
+	if edges != 2 || err != nil {
+		t.Errorf("CountEdges() produced %d,%v but expected 2,nil", edges, err)
+	}
+}
+
+func TestUpdateNode(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	count, err := AddNode("2", []byte(woz), file)
+	if count != 1 || err != nil {
+		t.Errorf("AddNode() inserted %d,%v but expected 1,nil", count, err)
+	}
+
+	count, err = UpdateNode("2", []byte(`{"name":"Steve Wozniak","nickname":"Woz"}`), file)
+	if count != 1 || err != nil {
+		t.Errorf("UpdateNode() updated %d,%v but expected 1,nil", count, err)
+	}
+
+	expected := `{"name":"Steve Wozniak","nickname":"Woz","id":"2"}`
+	node, err := FindNode("2", file)
+	if node != expected || err != nil {
+		t.Errorf("FindNode() produced %q,%v but expected %q,nil", node, err, expected)
+	}
+
+	count, err = UpdateNode("2", []byte(`{"id":"9","name":"Steve Wozniak"}`), file)
+	if count != 1 || err != nil {
+		t.Errorf("UpdateNode() updated %d,%v but expected 1,nil", count, err)
+	}
+
+	expected = `{"id":"2","name":"Steve Wozniak"}`
+	node, err = FindNode("2", file)
+	if node != expected || err != nil {
+		t.Errorf("FindNode() produced %q,%v but expected %q,nil", node, err, expected)
+	}
+
+	count, err = UpdateNode("7", []byte(jobs), file)
+	if count != 0 || err != nil {
+		t.Errorf("UpdateNode() updated %d,%v but expected 0,nil", count, err)
+	}
+}
+
+func TestPatchNode(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+	AddNode("4", []byte(wayne), file)
+	AddNode("1", []byte(apple), file)
+	ConnectNodesWithProperties("4", "1", []byte(founded), file)
+
+	count, err := PatchNode("4", []byte(`{"type":null,"address":{"city":"Pahrump"},"id":"5"}`), file)
+	if count != 1 || err != nil {
+		t.Errorf("PatchNode() patched %d,%v but expected 1,nil", count, err)
+	}
+	expected := `{"id":"4","name":"Ronald Wayne","address":{"city":"Pahrump"}}`
+	node, err := FindNode("4", file)
+	if node != expected || err != nil {
+		t.Errorf("FindNode() produced %q,%v but expected %q,nil", node, err, expected)
+	}
+
+	count, err = PatchNode("4", []byte(`{"address":{"state":"NV"}}`), file)
+	expected = `{"id":"4","name":"Ronald Wayne","address":{"city":"Pahrump","state":"NV"}}`
+	node, _ = FindNode("4", file)
+	if count != 1 || err != nil || node != expected {
+		t.Errorf("PatchNode() produced %d,%v and %q but expected 1,nil and %q", count, err, node, expected)
+	}
+	edges, _ := GetEdgesBetween("4", "1", file)
+	if len(edges) != 1 {
+		t.Errorf("PatchNode() left %v but expected the edge to be kept", edges)
+	}
+
+	count, err = PatchNode("9", []byte(`{"name":"nobody"}`), file)
+	if count != 0 || err != nil {
+		t.Errorf("PatchNode() on a missing node produced %d,%v but expected 0,nil", count, err)
+	}
+	for _, patch := range []string{`{"name":`, `["name"]`, `null`} {
+		_, err = PatchNode("4", []byte(patch), file)
+		if !ErrorMatches(err, INVALID_NODE_JSON) {
+			t.Errorf("PatchNode(%s) produced %v but expected %q", patch, err, INVALID_NODE_JSON)
+		}
+	}
+
+	graph, _ := NewGraphWithOptions([]Option{WithCanonicalJSON()}, file)
+	defer graph.Close()
+	count, err = graph.PatchNode("1", []byte(`{"founded":1976}`))
+	expected = `{"founded":1976,"id":"1","name":"Apple Computer Company","type":["company","start-up"]}`
+	node, _ = graph.FindNode("1")
+	if count != 1 || err != nil || node != expected {
+		t.Errorf("PatchNode() with canonical JSON produced %d,%v and %q but expected 1,nil and %q", count, err, node, expected)
+	}
+	count, err = graph.PatchNode("9", []byte(`{"name":"nobody"}`))
+	if count != 0 || err != nil {
+		t.Errorf("PatchNode() with canonical JSON on a missing node produced %d,%v but expected 0,nil", count, err)
+	}
+}
+
+func TestUpdateNodesWhere(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+	AddNodes([]string{"1", "2", "3"}, [][]byte{[]byte(apple), []byte(woz), []byte(jobs)}, file)
+	ConnectNodesWithProperties("2", "1", []byte(founded), file)
+
+	personType := "EXISTS (SELECT 1 FROM json_each(nodes.body, '$.type') WHERE json_each.value = ?)"
+	count, err := UpdateNodesWhere(personType, []interface{}{"person"}, []byte(`{"status":"archived","id":"9"}`), file)
+	if count != 2 || err != nil {
+		t.Errorf("UpdateNodesWhere() produced %d,%v but expected 2,nil", count, err)
+	}
+	archived, err := FindNodesWhere("json_extract(body, '$.status') = ?", []interface{}{"archived"}, file)
+	expected := []string{
+		`{"id":"2","name":"Steve Wozniak","type":["person","engineer","founder"],"status":"archived"}`,
+		`{"id":"3","name":"Steve Jobs","type":["person","designer","founder"],"status":"archived"}`,
+	}
+	if len(archived) != len(expected) || err != nil {
+		t.Fatalf("FindNodesWhere() produced %v,%v but expected %v,nil", archived, err, expected)
+	}
+	for i, body := range expected {
+		if archived[i] != body {
+			t.Errorf("UpdateNodesWhere() left %q but expected %q", archived[i], body)
+		}
+	}
+	edges, _ := GetEdgesBetween("2", "1", file)
+	if len(edges) != 1 {
+		t.Errorf("UpdateNodesWhere() left %v but expected the edge to be kept", edges)
+	}
+
+	count, err = UpdateNodesWhere("id = ?", []interface{}{"99"}, []byte(`{"status":"archived"}`), file)
+	if count != 0 || err != nil {
+		t.Errorf("UpdateNodesWhere() matching nothing produced %d,%v but expected 0,nil", count, err)
+	}
+	_, err = UpdateNodesWhere(" ", nil, []byte(`{"status":"archived"}`), file)
+	if !ErrorMatches(err, "empty where clause") {
+		t.Errorf("UpdateNodesWhere() with an empty clause produced %v but expected an error", err)
+	}
+	_, err = UpdateNodesWhere("id = ?", []interface{}{"1"}, []byte(`["status"]`), file)
+	if !ErrorMatches(err, INVALID_NODE_JSON) {
+		t.Errorf("UpdateNodesWhere() with an array patch produced %v but expected %q", err, INVALID_NODE_JSON)
+	}
+
+	graph, _ := NewGraphWithOptions([]Option{WithCanonicalJSON()}, file)
+	defer graph.Close()
+	count, err = graph.UpdateNodesWhere("id IN (?, ?)", []interface{}{"1", "2"}, []byte(`{"status":"active"}`))
+	if count != 2 || err != nil {
+		t.Errorf("UpdateNodesWhere() with canonical JSON produced %d,%v but expected 2,nil", count, err)
+	}
+	node, _ := graph.FindNode("2")
+	expectedNode := `{"id":"2","name":"Steve Wozniak","status":"active","type":["person","engineer","founder"]}`
+	if node != expectedNode {
+		t.Errorf("UpdateNodesWhere() with canonical JSON left %q but expected %q", node, expectedNode)
+	}
+}
+
+func TestUpsertNode(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	count, err := UpsertNode("4", []byte(wayne), file)
+	if count != 1 || err != nil {
+		t.Errorf("UpsertNode() upserted %d,%v but expected 1,nil", count, err)
+	}
+
+	expected := `{"name":"Ronald Wayne","type":["person","administrator","founder"],"id":"4"}`
+	node, err := FindNode("4", file)
+	if node != expected || err != nil {
+		t.Errorf("FindNode() produced %q,%v but expected %q,nil", node, err, expected)
+	}
+
+	count, err = UpsertNode("4", []byte(`{"name":"Ronald Wayne","type":["person","founder"]}`), file)
+	if count != 1 || err != nil {
+		t.Errorf("UpsertNode() upserted %d,%v but expected 1,nil", count, err)
+	}
+
+	expected = `{"name":"Ronald Wayne","type":["person","founder"],"id":"4"}`
+	node, err = FindNode("4", file)
+	if node != expected || err != nil {
+		t.Errorf("FindNode() produced %q,%v but expected %q,nil", node, err, expected)
+	}
+
+	count, err = UpsertNode("3", []byte(jobs), file)
+	if count != 1 || err != nil {
+		t.Errorf("UpsertNode() upserted %d,%v but expected 1,nil", count, err)
+	}
+
+	count, err = UpsertNode("3", []byte(jobs), file)
+	if count != 1 || err != nil {
+		t.Errorf("UpsertNode() upserted %d,%v but expected 1,nil", count, err)
+	}
+
+	node, err = FindNode("3", file)
+	if node != jobs || err != nil {
+		t.Errorf("FindNode() produced %q,%v but expected %q,nil", node, err, jobs)
+	}
+}
+
+func TestRemoveEdge(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	AddNodes([]string{"1", "2", "3"}, [][]byte{[]byte(apple), []byte(woz), []byte(jobs)}, file)
+	BulkConnectNodesWithProperties([]string{"2", "2", "3", "3", "3"},
+		[]string{"1", "1", "1", "1", "1"},
+		[]string{founded, founded, founded, founded, invested}, file)
+
+	count, err := RemoveEdge("2", "1", file)
+	if count != 2 || err != nil {
+		t.Errorf("RemoveEdge() removed %d,%v but expected 2,nil", count, err)
+	}
+
+	count, err = RemoveEdge("2", "1", file)
+	if count != 0 || err != nil {
+		t.Errorf("RemoveEdge() removed %d,%v but expected 0,nil", count, err)
+	}
+
+	count, err = RemoveEdgeWithProperties("3", "1", []byte(`{"action": "founded"}`), file)
+	if count != 1 || err != nil {
+		t.Errorf("RemoveEdgeWithProperties() removed %d,%v but expected 1,nil", count, err)
+	}
+
+	edges, err := Connections("3", file)
+	if err != nil {
+		t.Errorf("Connections() produced an error %s but expected nil", err.Error())
+	}
+	if len(edges) != 2 {
+		t.Errorf("Connections() produced %d edges but expected 2", len(edges))
+	}
+}
+
+func TestContextCancellation(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	count, err := AddNodeContext(ctx, "1", []byte(apple), file)
+	if count != 1 || err != nil {
+		t.Errorf("AddNodeContext() inserted %d,%v but expected 1,nil", count, err)
+	}
+	cancel()
+
+	count, err = AddNodeContext(ctx, "3", []byte(jobs), file)
+	if count != 0 || !errors.Is(err, context.Canceled) {
+		t.Errorf("AddNodeContext() inserted %d,%v but expected 0,%v", count, err, context.Canceled)
+	}
+
+	count, err = ConnectNodesContext(ctx, "1", "1", file)
+	if count != 0 || !errors.Is(err, context.Canceled) {
+		t.Errorf("ConnectNodesContext() inserted %d,%v but expected 0,%v", count, err, context.Canceled)
+	}
+
+	count, err = RemoveEdgeContext(ctx, "1", "1", file)
+	if count != 0 || !errors.Is(err, context.Canceled) {
+		t.Errorf("RemoveEdgeContext() removed %d,%v but expected 0,%v", count, err, context.Canceled)
+	}
+
+	node, err := FindNodeContext(ctx, "1", file)
+	if node != "" || !errors.Is(err, context.Canceled) {
+		t.Errorf("FindNodeContext() produced %q,%v but expected \"\",%v", node, err, context.Canceled)
+	}
+
+	removed, err := RemoveNodesContext(ctx, []string{"1"}, file)
+	if removed != 0 || !errors.Is(err, context.Canceled) {
+		t.Errorf("RemoveNodesContext() removed %d,%v but expected 0,%v", removed, err, context.Canceled)
+	}
+
+	node, err = FindNode("1", file)
+	if node != apple || err != nil {
+		t.Errorf("FindNode() produced %q,%v but expected %q,nil", node, err, apple)
+	}
+}
+
+func TestFindNodesByJSONPath(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	berlin := `{"id":"1","address":{"city":"Berlin","zip":10115},"active":true,"score":4.5}`
+	paris := `{"id":"2","address":{"city":"Paris","zip":75001},"active":false,"score":3}`
+	AddNodes([]string{"1", "2"}, [][]byte{[]byte(berlin), []byte(paris)}, file)
+
+	for _, test := range []struct {
+		path     string
+		value    interface{}
+		expected []string
+	}{
+		{"$.address.city", "Berlin", []string{berlin}},
+		{"$.address.zip", 75001, []string{paris}},
+		{"$.active", true, []string{berlin}},
+		{"$.active", false, []string{paris}},
+		{"$.score", 4.5, []string{berlin}},
+		{"$.address.city", "Berlin' OR 1=1 --", []string{}},
+	} {
+		nodes, err := FindNodesByJSONPath(test.path, test.value, file)
+		if fmt.Sprint(nodes) != fmt.Sprint(test.expected) || err != nil {
+			t.Errorf("FindNodesByJSONPath(%q, %v) produced %v,%v but expected %v,nil", test.path, test.value, nodes, err, test.expected)
+		}
+	}
+
+	_, err := FindNodesByJSONPath("address.city", "Berlin", file)
+	if err == nil {
+		t.Errorf("FindNodesByJSONPath() produced nil but expected an error for a path without $")
+	}
+	_, err = FindNodesByJSONPath("$.address", []string{"Berlin"}, file)
+	if err == nil {
+		t.Errorf("FindNodesByJSONPath() produced nil but expected an error for a slice value")
+	}
+}
+
+func TestDistinctValues(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	AddNodes([]string{"1", "2", "3", "4", "5"}, [][]byte{
+		[]byte(`{"kind":"person","address":{"city":"Paris"}}`),
+		[]byte(`{"kind":"company","address":{"city":"Berlin"}}`),
+		[]byte(`{"kind":"person","address":{"city":"Paris"}}`),
+		[]byte(`{"kind":null}`),
+		[]byte(`{"name":"no kind"}`),
+	}, file)
+
+	for _, test := range []struct {
+		path     string
+		expected []string
+	}{
+		{"$.kind", []string{"company", "person"}},
+		{"$.address.city", []string{"Berlin", "Paris"}},
+		{"$.id", []string{"1", "2", "3", "4", "5"}},
+		{"$.missing", []string{}},
+	} {
+		values, err := DistinctValues(test.path, file)
+		if fmt.Sprint(values) != fmt.Sprint(test.expected) || err != nil {
+			t.Errorf("DistinctValues(%q) produced %v,%v but expected %v,nil", test.path, values, err, test.expected)
+		}
+	}
+
+	_, err := DistinctValues("kind", file)
+	if !ErrorMatches(err, `invalid JSON path "kind"`) {
+		t.Errorf("DistinctValues() produced %v but expected an error for a path without $", err)
+	}
+}
+
+func TestCountByProperty(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	AddNodes([]string{"1", "2", "3", "4", "5", "6"}, [][]byte{
+		[]byte(`{"kind":"person"}`),
+		[]byte(`{"kind":"company"}`),
+		[]byte(`{"kind":"person"}`),
+		[]byte(`{"kind":null}`),
+		[]byte(`{"name":"no kind"}`),
+		[]byte(`{"kind":7}`),
+	}, file)
+	AddNode("7", []byte(`{"kind":"7"}`), file)
+
+	counts, err := CountByProperty("$.kind", file)
+	expected := map[string]int64{"person": 2, "company": 1, "7": 2}
+	if fmt.Sprint(counts) != fmt.Sprint(expected) || err != nil {
+		t.Errorf("CountByProperty() produced %v,%v but expected %v,nil", counts, err, expected)
+	}
+	counts, err = CountByProperty("$.missing", file)
+	if counts == nil || len(counts) != 0 || err != nil {
+		t.Errorf("CountByProperty() on a missing path produced %v,%v but expected an empty map,nil", counts, err)
+	}
+	_, err = CountByProperty("kind", file)
+	if !ErrorMatches(err, `invalid JSON path "kind"`) {
+		t.Errorf("CountByProperty() produced %v but expected an error for a path without $", err)
+	}
+}
+
+func TestGetNodesByType(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	device := `{"id":"5","name":"Apple I","type":"computer"}`
+	AddNodes([]string{"1", "2", "3", "5"}, [][]byte{[]byte(apple), []byte(woz), []byte(jobs), []byte(device)}, file)
+	AddNode("6", []byte(`{"name":"untyped"}`), file)
+
+	for nodeType, expected := range map[string][]string{
+		"founder":  {woz, jobs},
+		"company":  {apple},
+		"computer": {device},
+		"missing":  {},
+	} {
+		nodes, err := GetNodesByType(nodeType, file)
+		if fmt.Sprint(nodes) != fmt.Sprint(expected) || err != nil {
+			t.Errorf("GetNodesByType(%q) produced %v,%v but expected %v,nil", nodeType, nodes, err, expected)
+		}
+	}
+}
+
+func TestFindNodesByProperty(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	AddNodes([]string{"1", "2", "3"}, [][]byte{[]byte(apple), []byte(woz), []byte(jobs)}, file)
+	AddNode("6", []byte(`{"full name":"Steve Jobs","a.b":"dotted"}`), file)
+
+	nodes, err := FindNodesByProperty("name", "Steve Jobs", file)
+	if err != nil {
+		t.Errorf("FindNodesByProperty() produced an error %s but expected nil", err.Error())
+	}
+	if len(nodes) != 1 || nodes[0] != jobs {
+		t.Errorf("FindNodesByProperty() produced %v but expected [%s]", nodes, jobs)
+	}
+
+	nodes, err = FindNodesByProperty("name", "Mike Markkula", file)
+	if nodes == nil || len(nodes) != 0 || err != nil {
+		t.Errorf("FindNodesByProperty() produced %v,%v but expected [],nil", nodes, err)
+	}
+
+	for key, value := range map[string]string{"full name": "Steve Jobs", "a.b": "dotted"} {
+		nodes, err = FindNodesByProperty(key, value, file)
+		if len(nodes) != 1 || err != nil {
+			t.Errorf("FindNodesByProperty(%q) produced %v,%v but expected one node", key, nodes, err)
+		}
+	}
+
+	_, err = FindNodesByProperty(`name") OR 1=1 --`, "x", file)
+	if err == nil {
+		t.Errorf("FindNodesByProperty() produced nil but expected an error")
+	}
+}
+
+func TestGetNeighbors(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	AddNodes([]string{"1", "2", "3", "5"}, [][]byte{[]byte(apple), []byte(woz), []byte(jobs), []byte(markkula)}, file)
+	BulkConnectNodesWithProperties([]string{"2", "3", "1", "1", "2"},
+		[]string{"1", "1", "2", "1", "1"},
+		[]string{founded, founded, `{}`, `{}`, invested}, file)
+
+	ids, err := GetNeighbors("1", file)
+	if err != nil {
+		t.Errorf("GetNeighbors() produced an error %s but expected nil", err.Error())
+	}
+	if len(ids) != 2 || !arrayContains(ids, "2") || !arrayContains(ids, "3") {
+		t.Errorf("GetNeighbors() produced %v but expected [2 3]", ids)
+	}
+
+	ids, err = GetNeighborsIncludingSelf("1", file)
+	if err != nil {
+		t.Errorf("GetNeighborsIncludingSelf() produced an error %s but expected nil", err.Error())
+	}
+	if len(ids) != 3 || !arrayContains(ids, "1") {
+		t.Errorf("GetNeighborsIncludingSelf() produced %v but expected [1 2 3]", ids)
+	}
+
+	ids, err = GetNeighbors("5", file)
+	if ids == nil || len(ids) != 0 || err != nil {
+		t.Errorf("GetNeighbors() produced %v,%v but expected [],nil", ids, err)
+	}
+}
+
+func TestDirectionalNeighbors(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	AddNodes([]string{"1", "2", "4", "5"}, [][]byte{[]byte(apple), []byte(woz), []byte(wayne), []byte(markkula)}, file)
+	BulkConnectNodesWithProperties([]string{"2", "5", "1"},
+		[]string{"1", "1", "4"},
+		[]string{founded, invested, divested}, file)
+
+	edges, err := GetOutgoing("1", file)
+	if err != nil {
+		t.Errorf("GetOutgoing() produced an error %s but expected nil", err.Error())
+	}
+	expected := []EdgeData{{"1", "4", divested}}
+	if len(edges) != len(expected) || edges[0] != expected[0] {
+		t.Errorf("GetOutgoing() produced %v but expected %v", edges, expected)
+	}
+
+	edges, err = GetIncoming("1", file)
+	if err != nil {
+		t.Errorf("GetIncoming() produced an error %s but expected nil", err.Error())
+	}
+	expected = []EdgeData{{"2", "1", founded}, {"5", "1", invested}}
+	if len(edges) != len(expected) {
+		t.Errorf("GetIncoming() produced %v but expected %v", edges, expected)
+	}
+	for i, exp := range expected {
+		if i < len(edges) && edges[i] != exp {
+			t.Errorf("GetIncoming() produced %v but expected %v", edges[i], exp)
+		}
+	}
+
+	edges, err = GetIncoming("2", file)
+	if len(edges) != 0 || err != nil {
+		t.Errorf("GetIncoming() produced %v,%v but expected [],nil", edges, err)
+	}
+}
+
+func TestAddNodesRollback(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	count, err := AddNodes([]string{"1", "2", "1"}, [][]byte{[]byte(apple), []byte(woz), []byte(apple)}, file)
+	expected := "node 2: " + UNIQUE_ID_CONSTRAINT
+	if count != 0 || !ErrorMatches(err, expected) {
+		t.Errorf("AddNodes() inserted %d,%v but expected 0,%q", count, err, expected)
+	}
+
+	node, err := FindNode("2", file)
+	if node != "" || err == nil {
+		t.Errorf("FindNode() produced %q,%v but expected the insert to be rolled back", node, err)
+	}
+
+	count, err = AddNodes([]string{"1", "2"}, [][]byte{[]byte(apple), []byte(`{"name":`)}, file)
+	if count != 0 || err == nil {
+		t.Errorf("AddNodes() inserted %d,%v but expected 0 and an error", count, err)
+	}
+}
+
+func makeBenchmarkNodes(n int) ([]string, [][]byte) {
+	identifiers := make([]string, n)
+	nodes := make([][]byte, n)
+	for i := 0; i < n; i++ {
+		identifiers[i] = fmt.Sprintf("%d", i)
+		nodes[i] = []byte(fmt.Sprintf("{\"name\":\"node %d\"}", i))
+	}
+	return identifiers, nodes
+}
+
+func BenchmarkAddNode(b *testing.B) {
+	identifiers, nodes := makeBenchmarkNodes(1000)
+	for n := 0; n < b.N; n++ {
+		file := "testdb.sqlite3"
+		Initialize(file)
+		for i := range nodes {
+			AddNode(identifiers[i], nodes[i], file)
+		}
+		os.Remove(file)
+	}
+}
+
+func BenchmarkAddNodes(b *testing.B) {
+	identifiers, nodes := makeBenchmarkNodes(1000)
+	for n := 0; n < b.N; n++ {
+		file := "testdb.sqlite3"
+		Initialize(file)
+		AddNodes(identifiers, nodes, file)
+		os.Remove(file)
+	}
+}
+
+func TestStatementCache(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	graph, err := NewGraph(file)
+	if err != nil {
+		t.Fatalf("NewGraph() produced an error %q but expected nil", err.Error())
+	}
+	defer graph.Close()
+	graph.AddNode("1", []byte(apple))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				node, err := graph.FindNode("1")
+				if err == nil && node != apple {
+					err = fmt.Errorf("FindNode() produced %q but expected %q", node, apple)
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("concurrent FindNode() failed: %v", err)
+	}
+
+	if len(graph.stmts) != 2 {
+		t.Errorf("statement cache held %d statements but expected 2", len(graph.stmts))
+	}
+
+	graph.Close()
+	if len(graph.stmts) != 0 {
+		t.Errorf("Close() left %d cached statements but expected 0", len(graph.stmts))
+	}
+}
+
+func benchmarkGraph(b *testing.B, file string) *Graph {
+	Initialize(file)
+	graph, err := NewGraph(file)
+	if err != nil {
+		b.Fatal(err)
+	}
+	graph.AddNode("1", []byte(apple))
+	return graph
+}
+
+func BenchmarkFindNodeUncached(b *testing.B) {
+	file := "testdb.sqlite3"
+	graph := benchmarkGraph(b, file)
+	defer os.Remove(file)
+	defer graph.Close()
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for i := 0; i < 10000; i++ {
+			stmt, _ := graph.db.Prepare(SearchNodeById)
+			var body string
+			stmt.QueryRow("1").Scan(&body)
+			stmt.Close()
+		}
+	}
+}
+
+func BenchmarkFindNodeCached(b *testing.B) {
+	file := "testdb.sqlite3"
+	graph := benchmarkGraph(b, file)
+	defer os.Remove(file)
+	defer graph.Close()
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for i := 0; i < 10000; i++ {
+			graph.FindNode("1")
+		}
+	}
+}
+
+func TestConnectNodesBatch(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	AddNodes([]string{"1", "2", "3", "4", "5"},
+		[][]byte{[]byte(apple), []byte(woz), []byte(jobs), []byte(wayne), []byte(markkula)}, file)
+
+	count, err := ConnectNodesBatch([]EdgeData{{"2", "1", founded}, {"3", "1", founded}, {"2", "3", ""}}, file)
+	if count != 3 || err != nil {
+		t.Errorf("ConnectNodesBatch() inserted %d,%v but expected 3,nil", count, err)
+	}
+
+	edges, err := ConnectionsIn("2", file)
+	expected := []EdgeData{{"2", "1", founded}, {"2", "3", `{}`}}
+	if len(edges) != len(expected) || err != nil {
+		t.Errorf("ConnectionsIn() produced %v,%v but expected %v,nil", edges, err, expected)
+	}
+	for i, exp := range expected {
+		if i < len(edges) && edges[i] != exp {
+			t.Errorf("ConnectionsIn() produced %v but expected %v", edges[i], exp)
+		}
+	}
+
+	count, err = ConnectNodesBatch([]EdgeData{{"4", "1", founded}, {"5", "7", invested}}, file)
+	expectedErr := "edge 1: FOREIGN KEY constraint failed"
+	if count != 0 || !ErrorMatches(err, expectedErr) {
+		t.Errorf("ConnectNodesBatch() inserted %d,%v but expected 0,%q", count, err, expectedErr)
+	}
+
+	edges, err = ConnectionsIn("4", file)
+	if len(edges) != 0 || err != nil {
+		t.Errorf("ConnectionsIn() produced %v,%v but expected the batch to be rolled back", edges, err)
+	}
+}
+
+func TestConnectFromAdjacency(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	AddNodes([]string{"1", "2", "3", "4"}, [][]byte{[]byte(apple), []byte(woz), []byte(jobs), []byte(wayne)}, file)
+
+	count, err := ConnectFromAdjacency(map[string][]string{
+		"3": {"1"},
+		"2": {"1", "3"},
+		"4": {},
+	}, file)
+	if count != 3 || err != nil {
+		t.Errorf("ConnectFromAdjacency() inserted %d,%v but expected 3,nil", count, err)
+	}
+	edges, _ := ListEdges(0, 0, file)
+	expected := []EdgeData{{"2", "1", "{}"}, {"2", "3", "{}"}, {"3", "1", "{}"}}
+	if fmt.Sprint(edges) != fmt.Sprint(expected) {
+		t.Errorf("ListEdges() produced %v but expected %v", edges, expected)
+	}
+
+	count, err = ConnectFromAdjacency(map[string][]string{"4": {"1", "9"}}, file)
+	if count != 0 || !ErrorMatches(err, "edge 1: FOREIGN KEY constraint failed") {
+		t.Errorf("ConnectFromAdjacency() inserted %d,%v but expected the batch to fail", count, err)
+	}
+	edges, _ = ConnectionsIn("4", file)
+	if len(edges) != 0 {
+		t.Errorf("ConnectionsIn() produced %v but expected the batch to be rolled back", edges)
+	}
+}
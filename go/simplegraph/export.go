@@ -0,0 +1,665 @@
+package simplegraph
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+type exportedEdge struct {
+	Source     string          `json:"source"`
+	Target     string          `json:"target"`
+	Properties json.RawMessage `json:"properties"`
+}
+
+type exportedGraph struct {
+	Nodes []json.RawMessage `json:"nodes"`
+	Edges []exportedEdge    `json:"edges"`
+}
+
+func (g *Graph) eachEdge(fn func(edge EdgeData) error) error {
+	stmt, err := g.prepare(context.Background(), SearchAllEdges)
+	if err != nil {
+		return err
+	}
+	rows, err := stmt.Query(-1, 0)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var edge EdgeData
+		err = rows.Scan(&edge.Source, &edge.Target, &edge.Label)
+		if err != nil {
+			return err
+		}
+		err = fn(edge)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// decodeObject keeps numbers as json.Number so identifiers like 1 are not
+// rendered as floats
+func decodeObject(body string) (map[string]interface{}, error) {
+	decoder := json.NewDecoder(strings.NewReader(body))
+	decoder.UseNumber()
+	fields := map[string]interface{}{}
+	err := decoder.Decode(&fields)
+	if err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+func decodeProperties(edge EdgeData) (map[string]interface{}, error) {
+	if len(edge.Label) == 0 {
+		return map[string]interface{}{}, nil
+	}
+	return decodeObject(edge.Label)
+}
+
+func (g *Graph) nodePropertyNames() (map[string]bool, error) {
+	names := map[string]bool{}
+	err := g.IterateNodes(func(body string) error {
+		fields, err := decodeObject(body)
+		if err != nil {
+			return err
+		}
+		for name := range fields {
+			names[name] = true
+		}
+		return nil
+	})
+	return names, err
+}
+
+func (g *Graph) edgePropertyNames() (map[string]bool, error) {
+	names := map[string]bool{}
+	err := g.eachEdge(func(edge EdgeData) error {
+		fields, err := decodeProperties(edge)
+		if err != nil {
+			return err
+		}
+		for name := range fields {
+			names[name] = true
+		}
+		return nil
+	})
+	return names, err
+}
+
+func sortedNames(names map[string]bool) []string {
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+func dotQuote(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`)
+	return `"` + replacer.Replace(value) + `"`
+}
+
+// writeDOT fills in the node whose id is center, if any, so it stands out
+func writeDOT(w io.Writer, center string, eachNode func(func(body string) error) error, eachEdge func(func(edge EdgeData) error) error) error {
+	out := bufio.NewWriter(w)
+	_, err := out.WriteString("digraph {\n")
+	if err != nil {
+		return err
+	}
+
+	err = eachNode(func(body string) error {
+		fields, err := decodeObject(body)
+		if err != nil {
+			return err
+		}
+		identifier := fmt.Sprint(fields["id"])
+		attributes := []string{}
+		if name, ok := fields["name"].(string); ok {
+			attributes = append(attributes, "label="+dotQuote(name))
+		}
+		if center != "" && identifier == center {
+			attributes = append(attributes, "style=filled", "fillcolor=lightblue")
+		}
+		line := "  " + dotQuote(identifier)
+		if len(attributes) > 0 {
+			line += " [" + strings.Join(attributes, ", ") + "]"
+		}
+		_, err = out.WriteString(line + ";\n")
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	err = eachEdge(func(edge EdgeData) error {
+		line := "  " + dotQuote(edge.Source) + " -> " + dotQuote(edge.Target)
+		if len(edge.Label) > 0 && edge.Label != `{}` {
+			line += " [label=" + dotQuote(edge.Label) + "]"
+		}
+		_, err := out.WriteString(line + ";\n")
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = out.WriteString("}\n")
+	if err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+func (g *Graph) ExportDOT(w io.Writer) error {
+	return writeDOT(w, "", g.IterateNodes, g.eachEdge)
+}
+
+func ExportDOT(w io.Writer, database ...string) error {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return err
+	}
+	defer graph.Close()
+	return graph.ExportDOT(w)
+}
+
+// WriteDOT renders nodes and edges that are already in hand, such as those
+// returned by Subgraph, in the same format as ExportDOT
+func WriteDOT(w io.Writer, nodes []string, edges []EdgeData) error {
+	return writeSubgraphDOT(w, "", nodes, edges)
+}
+
+func writeSubgraphDOT(w io.Writer, center string, nodes []string, edges []EdgeData) error {
+	eachNode := func(fn func(body string) error) error {
+		for _, node := range nodes {
+			err := fn(node)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	eachEdge := func(fn func(edge EdgeData) error) error {
+		for _, edge := range edges {
+			err := fn(edge)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return writeDOT(w, center, eachNode, eachEdge)
+}
+
+// ExportEgoNetworkDOT writes the nodes within k edges of center, following
+// edges either way, and the edges among them, in the same format as
+// ExportDOT, with the center node filled in
+func (g *Graph) ExportEgoNetworkDOT(w io.Writer, center string, k int) error {
+	if k <= 0 {
+		return errors.New("k must be greater than zero")
+	}
+	nodes, edges, err := g.Subgraph([]string{center}, k)
+	if err != nil {
+		return err
+	}
+	if len(nodes) == 0 {
+		return notFoundError{identifier: center, err: sql.ErrNoRows}
+	}
+	return writeSubgraphDOT(w, center, nodes, edges)
+}
+
+func ExportEgoNetworkDOT(w io.Writer, center string, k int, database ...string) error {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return err
+	}
+	defer graph.Close()
+	return graph.ExportEgoNetworkDOT(w, center, k)
+}
+
+func (g *Graph) ExportJSON(w io.Writer) error {
+	out := bufio.NewWriter(w)
+	_, err := out.WriteString(`{"nodes":[`)
+	if err != nil {
+		return err
+	}
+
+	separator := ""
+	err = g.IterateNodes(func(body string) error {
+		_, err := out.WriteString(separator + body)
+		separator = ","
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = out.WriteString(`],"edges":[`)
+	if err != nil {
+		return err
+	}
+
+	separator = ""
+	err = g.eachEdge(func(edge EdgeData) error {
+		properties := edge.Label
+		if len(properties) == 0 {
+			properties = `{}`
+		}
+		encoded, err := json.Marshal(exportedEdge{edge.Source, edge.Target, json.RawMessage(properties)})
+		if err != nil {
+			return err
+		}
+		_, err = out.WriteString(separator)
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(encoded)
+		separator = ","
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = out.WriteString("]}\n")
+	if err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+func ExportJSON(w io.Writer, database ...string) error {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return err
+	}
+	defer graph.Close()
+	return graph.ExportJSON(w)
+}
+
+func (g *Graph) ImportJSON(r io.Reader) error {
+	if err := g.writable(); err != nil {
+		return err
+	}
+	var imported exportedGraph
+	err := json.NewDecoder(r).Decode(&imported)
+	if err != nil {
+		return err
+	}
+
+	return g.WithTransaction(func(tx *Tx) error {
+		for i, node := range imported.Nodes {
+			missing, err := needsIdentifier(node)
+			if err != nil {
+				return fmt.Errorf("node %d: %w", i, err)
+			}
+			if missing {
+				return fmt.Errorf("node %d has no id", i)
+			}
+			_, err = insertNode(tx.ctx, tx, "", node)
+			if err != nil {
+				return fmt.Errorf("node %d: %w", i, err)
+			}
+		}
+		for i, edge := range imported.Edges {
+			for _, endpoint := range []string{edge.Source, edge.Target} {
+				exists, err := nodeExists(tx.ctx, tx, endpoint)
+				if err != nil {
+					return fmt.Errorf("edge %d: %w", i, err)
+				}
+				if !exists {
+					return fmt.Errorf("edge %d: node %q does not exist", i, endpoint)
+				}
+			}
+			properties := []byte(edge.Properties)
+			if len(properties) == 0 || string(properties) == "null" {
+				properties = []byte(`{}`)
+			}
+			_, err := connectNodes(tx.ctx, tx, edge.Source, edge.Target, properties)
+			if err != nil {
+				return fmt.Errorf("edge %d: %w", i, err)
+			}
+		}
+		return nil
+	})
+}
+
+func ImportJSON(r io.Reader, database ...string) error {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return err
+	}
+	defer graph.Close()
+	return graph.ImportJSON(r)
+}
+
+// ConflictPolicy says what ImportNodes does with a node whose id is taken
+type ConflictPolicy int
+
+const (
+	// Abort fails the whole import, leaving the database as it was
+	Abort ConflictPolicy = iota
+	// Ignore keeps the node already there and skips the new one
+	Ignore
+	// Replace overwrites the body of the node already there; it updates the
+	// row in place rather than running INSERT OR REPLACE, which would delete
+	// it first, so the node keeps its edges. The id must be a JSON string
+	Replace
+)
+
+// ImportNodes adds the nodes, each of which must carry its own id, in one
+// transaction; inserted counts the nodes written, replaced ones included,
+// and skipped the ones Ignore left out
+func (g *Graph) ImportNodes(nodes [][]byte, policy ConflictPolicy) (inserted int64, skipped int64, err error) {
+	var statement string
+	switch policy {
+	case Abort:
+		statement = InsertNode
+	case Ignore:
+		statement = InsertNodeIfAbsent
+	case Replace:
+		statement = InsertOrUpdateNode
+	default:
+		return 0, 0, fmt.Errorf("unknown conflict policy %d", policy)
+	}
+	err = g.WithTransaction(func(tx *Tx) error {
+		inserted, skipped = 0, 0
+		for i, node := range nodes {
+			err := tx.checkNode(node)
+			if err != nil {
+				return fmt.Errorf("node %d: %w", i, err)
+			}
+			var nodeData NodeData
+			err = json.Unmarshal(node, &nodeData)
+			if err != nil {
+				return fmt.Errorf("node %d: %w", i, err)
+			}
+			if nodeData.Identifier == nil {
+				return fmt.Errorf("node %d has no id", i)
+			}
+			if tx.canonicalJSON() {
+				node, err = canonicalize(node)
+				if err != nil {
+					return fmt.Errorf("node %d: %w", i, err)
+				}
+			}
+			args := []interface{}{string(node)}
+			if policy == Replace {
+				// InsertOrUpdateNode sets the id it is given as a string
+				identifier, ok := nodeData.Identifier.(string)
+				if !ok {
+					return fmt.Errorf("node %d: id %v is not a string", i, nodeData.Identifier)
+				}
+				args = append(args, identifier)
+			}
+			count, err := execAffected(tx.ctx, tx, statement, args...)
+			if err != nil {
+				return fmt.Errorf("node %d: %w", i, err)
+			}
+			inserted += count
+			skipped += 1 - count
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return inserted, skipped, nil
+}
+
+func ImportNodes(nodes [][]byte, policy ConflictPolicy, database ...string) (int64, int64, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer graph.Close()
+	return graph.ImportNodes(nodes, policy)
+}
+
+func xmlEscape(value string) string {
+	var escaped strings.Builder
+	xml.EscapeText(&escaped, []byte(value))
+	return escaped.String()
+}
+
+// textValue writes strings as they are and anything else as its JSON encoding
+func textValue(value interface{}) (string, error) {
+	if text, ok := value.(string); ok {
+		return text, nil
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+func graphMLKeys(prefix string, names map[string]bool) ([]string, map[string]string) {
+	sorted := sortedNames(names)
+	keys := map[string]string{}
+	for i, name := range sorted {
+		keys[name] = fmt.Sprintf("%s%d", prefix, i)
+	}
+	return sorted, keys
+}
+
+func writeGraphMLData(out *bufio.Writer, fields map[string]interface{}, keys map[string]string, names []string) error {
+	for _, name := range names {
+		value, ok := fields[name]
+		if !ok {
+			continue
+		}
+		text, err := textValue(value)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(out, "      <data key=\"%s\">%s</data>\n", keys[name], xmlEscape(text))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *Graph) ExportGraphML(w io.Writer) error {
+	// a first pass collects the union of property names, since every key
+	// has to be declared before the graph itself
+	nodeNames, err := g.nodePropertyNames()
+	if err != nil {
+		return err
+	}
+	delete(nodeNames, "id")
+	edgeNames, err := g.edgePropertyNames()
+	if err != nil {
+		return err
+	}
+	sortedNodeNames, nodeKeys := graphMLKeys("n", nodeNames)
+	sortedEdgeNames, edgeKeys := graphMLKeys("e", edgeNames)
+
+	out := bufio.NewWriter(w)
+	_, err = out.WriteString(xml.Header + "<graphml xmlns=\"http://graphml.graphdrawing.org/xmlns\">\n")
+	if err != nil {
+		return err
+	}
+	for _, name := range sortedNodeNames {
+		_, err = fmt.Fprintf(out, "  <key id=\"%s\" for=\"node\" attr.name=\"%s\" attr.type=\"string\"/>\n", nodeKeys[name], xmlEscape(name))
+		if err != nil {
+			return err
+		}
+	}
+	for _, name := range sortedEdgeNames {
+		_, err = fmt.Fprintf(out, "  <key id=\"%s\" for=\"edge\" attr.name=\"%s\" attr.type=\"string\"/>\n", edgeKeys[name], xmlEscape(name))
+		if err != nil {
+			return err
+		}
+	}
+	_, err = out.WriteString("  <graph edgedefault=\"directed\">\n")
+	if err != nil {
+		return err
+	}
+
+	err = g.IterateNodes(func(body string) error {
+		fields, err := decodeObject(body)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(out, "    <node id=\"%s\">\n", xmlEscape(fmt.Sprint(fields["id"])))
+		if err != nil {
+			return err
+		}
+		err = writeGraphMLData(out, fields, nodeKeys, sortedNodeNames)
+		if err != nil {
+			return err
+		}
+		_, err = out.WriteString("    </node>\n")
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	err = g.eachEdge(func(edge EdgeData) error {
+		fields, err := decodeProperties(edge)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(out, "    <edge source=\"%s\" target=\"%s\">\n", xmlEscape(edge.Source), xmlEscape(edge.Target))
+		if err != nil {
+			return err
+		}
+		err = writeGraphMLData(out, fields, edgeKeys, sortedEdgeNames)
+		if err != nil {
+			return err
+		}
+		_, err = out.WriteString("    </edge>\n")
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = out.WriteString("  </graph>\n</graphml>\n")
+	if err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+func ExportGraphML(w io.Writer, database ...string) error {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return err
+	}
+	defer graph.Close()
+	return graph.ExportGraphML(w)
+}
+
+func csvRecord(prefix []string, fields map[string]interface{}, names []string) ([]string, error) {
+	record := append([]string{}, prefix...)
+	for _, name := range names {
+		value, ok := fields[name]
+		if !ok {
+			record = append(record, "")
+			continue
+		}
+		text, err := textValue(value)
+		if err != nil {
+			return nil, err
+		}
+		record = append(record, text)
+	}
+	return record, nil
+}
+
+func (g *Graph) ExportNodesCSV(w io.Writer) error {
+	names, err := g.nodePropertyNames()
+	if err != nil {
+		return err
+	}
+	delete(names, "id")
+	columns := sortedNames(names)
+
+	out := csv.NewWriter(w)
+	err = out.Write(append([]string{"id"}, columns...))
+	if err != nil {
+		return err
+	}
+	err = g.IterateNodes(func(body string) error {
+		fields, err := decodeObject(body)
+		if err != nil {
+			return err
+		}
+		record, err := csvRecord([]string{fmt.Sprint(fields["id"])}, fields, columns)
+		if err != nil {
+			return err
+		}
+		return out.Write(record)
+	})
+	if err != nil {
+		return err
+	}
+	out.Flush()
+	return out.Error()
+}
+
+func ExportNodesCSV(w io.Writer, database ...string) error {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return err
+	}
+	defer graph.Close()
+	return graph.ExportNodesCSV(w)
+}
+
+func (g *Graph) ExportEdgesCSV(w io.Writer) error {
+	names, err := g.edgePropertyNames()
+	if err != nil {
+		return err
+	}
+	columns := sortedNames(names)
+
+	out := csv.NewWriter(w)
+	err = out.Write(append([]string{"source", "target"}, columns...))
+	if err != nil {
+		return err
+	}
+	err = g.eachEdge(func(edge EdgeData) error {
+		fields, err := decodeProperties(edge)
+		if err != nil {
+			return err
+		}
+		record, err := csvRecord([]string{edge.Source, edge.Target}, fields, columns)
+		if err != nil {
+			return err
+		}
+		return out.Write(record)
+	})
+	if err != nil {
+		return err
+	}
+	out.Flush()
+	return out.Error()
+}
+
+func ExportEdgesCSV(w io.Writer, database ...string) error {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return err
+	}
+	defer graph.Close()
+	return graph.ExportEdgesCSV(w)
+}
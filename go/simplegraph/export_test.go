@@ -0,0 +1,298 @@
+package simplegraph
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestExportDOT(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	AddNodes([]string{"1", "2", "3"},
+		[][]byte{[]byte(apple), []byte(woz), []byte(`{"id":"3","name":"Steve \"Jobs\"\n"}`)}, file)
+	ConnectNodesWithProperties("2", "1", []byte(founded), file)
+	ConnectNodes("3", "1", file)
+
+	var out bytes.Buffer
+	err := ExportDOT(&out, file)
+	if err != nil {
+		t.Errorf("ExportDOT() produced an error %q but expected nil", err.Error())
+	}
+	expected := `digraph {
+  "1" [label="Apple Computer Company"];
+  "2" [label="Steve Wozniak"];
+  "3" [label="Steve \"Jobs\"\n"];
+  "2" -> "1" [label="{\"action\":\"founded\"}"];
+  "3" -> "1";
+}
+`
+	if out.String() != expected {
+		t.Errorf("ExportDOT() produced %q but expected %q", out.String(), expected)
+	}
+}
+
+func TestExportEgoNetworkDOT(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	AddNodes([]string{"1", "2", "3", "4"},
+		[][]byte{[]byte(apple), []byte(woz), []byte(jobs), []byte(wayne)}, file)
+	ConnectNodesWithProperties("2", "1", []byte(founded), file)
+	ConnectNodes("3", "2", file)
+	ConnectNodes("4", "3", file)
+
+	var out bytes.Buffer
+	err := ExportEgoNetworkDOT(&out, "2", 1, file)
+	expected := `digraph {
+  "2" [label="Steve Wozniak", style=filled, fillcolor=lightblue];
+  "1" [label="Apple Computer Company"];
+  "3" [label="Steve Jobs"];
+  "2" -> "1" [label="{\"action\":\"founded\"}"];
+  "3" -> "2";
+}
+`
+	if out.String() != expected || err != nil {
+		t.Errorf("ExportEgoNetworkDOT() produced %q,%v but expected %q,nil", out.String(), err, expected)
+	}
+
+	out.Reset()
+	err = ExportEgoNetworkDOT(&out, "99", 2, file)
+	if !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("ExportEgoNetworkDOT() on a missing node produced %v but expected %v", err, ErrNodeNotFound)
+	}
+	err = ExportEgoNetworkDOT(&out, "2", 0, file)
+	if !ErrorMatches(err, "k must be greater than zero") {
+		t.Errorf("ExportEgoNetworkDOT() with k of 0 produced %v but expected an error", err)
+	}
+}
+
+func TestExportJSON(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	var out bytes.Buffer
+	err := ExportJSON(&out, file)
+	expected := "{\"nodes\":[],\"edges\":[]}\n"
+	if out.String() != expected || err != nil {
+		t.Errorf("ExportJSON() produced %q,%v but expected %q,nil", out.String(), err, expected)
+	}
+
+	AddNodes([]string{"1", "2"}, [][]byte{[]byte(apple), []byte(woz)}, file)
+	ConnectNodesWithProperties("2", "1", []byte(founded), file)
+	ConnectNodes("1", "2", file)
+
+	out.Reset()
+	err = ExportJSON(&out, file)
+	if err != nil {
+		t.Errorf("ExportJSON() produced an error %q but expected nil", err.Error())
+	}
+	var exported exportedGraph
+	err = json.Unmarshal(out.Bytes(), &exported)
+	if err != nil {
+		t.Fatalf("ExportJSON() produced invalid JSON %q: %v", out.String(), err)
+	}
+	if len(exported.Nodes) != 2 || string(exported.Nodes[0]) != apple || string(exported.Nodes[1]) != woz {
+		t.Errorf("ExportJSON() produced nodes %q but expected %q", exported.Nodes, []string{apple, woz})
+	}
+	expectedEdges := []exportedEdge{{"2", "1", json.RawMessage(founded)}, {"1", "2", json.RawMessage(`{}`)}}
+	if len(exported.Edges) != len(expectedEdges) {
+		t.Fatalf("ExportJSON() produced edges %v but expected %v", exported.Edges, expectedEdges)
+	}
+	for i, edge := range exported.Edges {
+		expected := expectedEdges[i]
+		if edge.Source != expected.Source || edge.Target != expected.Target || string(edge.Properties) != string(expected.Properties) {
+			t.Errorf("ExportJSON() produced edge %v but expected %v", edge, expected)
+		}
+	}
+}
+
+func TestImportJSON(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	AddNodes([]string{"1", "2", "3"}, [][]byte{[]byte(apple), []byte(woz), []byte(jobs)}, file)
+	ConnectNodesWithProperties("2", "1", []byte(founded), file)
+	ConnectNodesWithProperties("3", "1", []byte(founded), file)
+	ConnectNodes("2", "3", file)
+
+	var exported bytes.Buffer
+	err := ExportJSON(&exported, file)
+	if err != nil {
+		t.Fatalf("ExportJSON() produced an error %q but expected nil", err.Error())
+	}
+
+	copied := "testcopy.sqlite3"
+	Initialize(copied)
+	defer os.Remove(copied)
+
+	err = ImportJSON(bytes.NewReader(exported.Bytes()), copied)
+	if err != nil {
+		t.Fatalf("ImportJSON() produced an error %q but expected nil", err.Error())
+	}
+	var reexported bytes.Buffer
+	err = ExportJSON(&reexported, copied)
+	if err != nil || reexported.String() != exported.String() {
+		t.Errorf("ExportJSON() after ImportJSON() produced %q,%v but expected %q,nil", reexported.String(), err, exported.String())
+	}
+
+	empty := "testempty.sqlite3"
+	Initialize(empty)
+	defer os.Remove(empty)
+
+	dangling := `{"nodes":[{"id":"1"}],"edges":[{"source":"1","target":"2","properties":{}}]}`
+	err = ImportJSON(strings.NewReader(dangling), empty)
+	expected := `edge 0: node "2" does not exist`
+	if !ErrorMatches(err, expected) {
+		t.Errorf("ImportJSON() produced %v but expected %q", err, expected)
+	}
+	count, err := CountNodes(empty)
+	if count != 0 || err != nil {
+		t.Errorf("CountNodes() after a failed ImportJSON() produced %d,%v but expected 0,nil", count, err)
+	}
+}
+
+func TestImportNodes(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+	AddNodes([]string{"1", "2"}, [][]byte{[]byte(apple), []byte(woz)}, file)
+	ConnectNodesWithProperties("2", "1", []byte(founded), file)
+
+	newWoz := `{"id":"2","name":"Woz"}`
+	batch := [][]byte{[]byte(newWoz), []byte(jobs)}
+
+	inserted, skipped, err := ImportNodes(batch, Abort, file)
+	if inserted != 0 || skipped != 0 || !ErrorMatches(err, "node 0: UNIQUE constraint failed: nodes.id") {
+		t.Errorf("ImportNodes() with Abort produced %d,%d,%v but expected 0,0 and a conflict on node 0", inserted, skipped, err)
+	}
+	if exists, _ := NodeExists("3", file); exists {
+		t.Errorf("ImportNodes() with Abort added node 3 but expected nothing to be written")
+	}
+
+	inserted, skipped, err = ImportNodes(batch, Ignore, file)
+	if inserted != 1 || skipped != 1 || err != nil {
+		t.Errorf("ImportNodes() with Ignore produced %d,%d,%v but expected 1,1,nil", inserted, skipped, err)
+	}
+	node, _ := FindNode("2", file)
+	if node != woz {
+		t.Errorf("ImportNodes() with Ignore left %q but expected %q", node, woz)
+	}
+
+	inserted, skipped, err = ImportNodes([][]byte{[]byte(newWoz), []byte(`{"id":"4","name":"Ronald Wayne"}`)}, Replace, file)
+	if inserted != 2 || skipped != 0 || err != nil {
+		t.Errorf("ImportNodes() with Replace produced %d,%d,%v but expected 2,0,nil", inserted, skipped, err)
+	}
+	node, _ = FindNode("2", file)
+	if node != newWoz {
+		t.Errorf("ImportNodes() with Replace left %q but expected %q", node, newWoz)
+	}
+	edges, _ := GetEdgesBetween("2", "1", file)
+	if len(edges) != 1 {
+		t.Errorf("ImportNodes() with Replace left %v but expected the edge to be kept", edges)
+	}
+
+	_, _, err = ImportNodes([][]byte{[]byte(`{"id":5,"name":"numbered"}`)}, Replace, file)
+	if !ErrorMatches(err, "node 0: id 5 is not a string") {
+		t.Errorf("ImportNodes() with Replace produced %v but expected an error for a numeric id", err)
+	}
+	_, _, err = ImportNodes([][]byte{[]byte(`{"name":"nobody"}`)}, Ignore, file)
+	if !ErrorMatches(err, "node 0 has no id") {
+		t.Errorf("ImportNodes() produced %v but expected an error for a node without an id", err)
+	}
+	_, _, err = ImportNodes(batch, ConflictPolicy(9), file)
+	if !ErrorMatches(err, "unknown conflict policy 9") {
+		t.Errorf("ImportNodes() produced %v but expected an error for an unknown policy", err)
+	}
+}
+
+func TestExportGraphML(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	AddNodes([]string{"1", "2"}, [][]byte{[]byte(`{"id":"1","name":"Apple & Co"}`), []byte(`{"id":"2","nickname":"<Woz>","age":25}`)}, file)
+	ConnectNodesWithProperties("2", "1", []byte(founded), file)
+
+	var out bytes.Buffer
+	err := ExportGraphML(&out, file)
+	if err != nil {
+		t.Fatalf("ExportGraphML() produced an error %q but expected nil", err.Error())
+	}
+	expected := xml.Header + `<graphml xmlns="http://graphml.graphdrawing.org/xmlns">
+  <key id="n0" for="node" attr.name="age" attr.type="string"/>
+  <key id="n1" for="node" attr.name="name" attr.type="string"/>
+  <key id="n2" for="node" attr.name="nickname" attr.type="string"/>
+  <key id="e0" for="edge" attr.name="action" attr.type="string"/>
+  <graph edgedefault="directed">
+    <node id="1">
+      <data key="n1">Apple &amp; Co</data>
+    </node>
+    <node id="2">
+      <data key="n0">25</data>
+      <data key="n2">&lt;Woz&gt;</data>
+    </node>
+    <edge source="2" target="1">
+      <data key="e0">founded</data>
+    </edge>
+  </graph>
+</graphml>
+`
+	if out.String() != expected {
+		t.Errorf("ExportGraphML() produced %q but expected %q", out.String(), expected)
+	}
+
+	decoder := xml.NewDecoder(&out)
+	for {
+		_, err = decoder.Token()
+		if err != nil {
+			break
+		}
+	}
+	if err != io.EOF {
+		t.Errorf("ExportGraphML() produced malformed XML: %v", err)
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	AddNodes([]string{"1", "2", "4"}, [][]byte{[]byte(apple), []byte(wozNick), []byte(wayne)}, file)
+	ConnectNodesWithProperties("2", "1", []byte(founded), file)
+	ConnectNodesWithProperties("4", "1", []byte(divested), file)
+	ConnectNodes("2", "4", file)
+
+	var out bytes.Buffer
+	err := ExportNodesCSV(&out, file)
+	expected := `id,founded,name,nickname,type
+1,"April 1, 1976",Apple Computer Company,,"[""company"",""start-up""]"
+2,,Steve Wozniak,Woz,"[""person"",""engineer"",""founder""]"
+4,,Ronald Wayne,,"[""person"",""administrator"",""founder""]"
+`
+	if out.String() != expected || err != nil {
+		t.Errorf("ExportNodesCSV() produced %q,%v but expected %q,nil", out.String(), err, expected)
+	}
+
+	out.Reset()
+	err = ExportEdgesCSV(&out, file)
+	expected = `source,target,action,amount,date
+2,1,founded,,
+4,1,divested,800,"April 12, 1976"
+2,4,,,
+`
+	if out.String() != expected || err != nil {
+		t.Errorf("ExportEdgesCSV() produced %q,%v but expected %q,nil", out.String(), err, expected)
+	}
+}
@@ -1,8 +1,15 @@
 module github.com/dpapathanasiou/simple-graph/go/simplegraph
 
-go 1.16
+go 1.18
 
 require (
-	github.com/goccy/go-graphviz v0.0.9 // indirect
+	github.com/goccy/go-graphviz v0.0.9
 	github.com/mattn/go-sqlite3 v1.14.7
 )
+
+require (
+	github.com/fogleman/gg v1.3.0 // indirect
+	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0 // indirect
+	github.com/pkg/errors v0.9.1 // indirect
+	golang.org/x/image v0.0.0-20200119044424-58c23975cae1 // indirect
+)
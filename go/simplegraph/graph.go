@@ -0,0 +1,382 @@
+package simplegraph
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Graph is safe to share between goroutines: database/sql hands each query
+// its own pooled connection, mu guards the statement cache and closed, and
+// the remaining fields never change once the graph is open
+type Graph struct {
+	db         *sql.DB
+	mu         sync.Mutex
+	stmts      map[string]*sql.Stmt
+	readOnly   bool
+	canonical  bool
+	undirected bool
+	validator  func(body []byte) error
+	retries    int
+	backoff    time.Duration
+	closed     bool
+	borrowed   bool
+	connector  *connector
+}
+
+// querier is satisfied by both *Graph and *Tx, so the same statements
+// can run either on their own or as part of a caller's transaction
+type querier interface {
+	prepare(ctx context.Context, statement string) (*sql.Stmt, error)
+	writable() error
+	canonicalJSON() bool
+	undirectedEdges() bool
+	checkNode(node []byte) error
+	retry(ctx context.Context, op func() error) error
+}
+
+func NewGraph(names ...string) (*Graph, error) {
+	return NewGraphWithOptions(nil, names...)
+}
+
+func NewGraphWithOptions(opts []Option, names ...string) (*Graph, error) {
+	config := newOptions(opts)
+	dbReference, err := config.reference(names...)
+	if err != nil {
+		return nil, err
+	}
+	connector := newConnector(dbReference, config.foreignKeys)
+	db := sql.OpenDB(connector)
+	if len(names) == 1 && names[0] == IN_MEMORY {
+		// the in-memory database only lives as long as a connection to it,
+		// so pin the pool to one connection which is never recycled
+		db.SetMaxOpenConns(1)
+		db.SetMaxIdleConns(1)
+		db.SetConnMaxLifetime(0)
+		db.SetConnMaxIdleTime(0)
+	} else {
+		db.SetMaxOpenConns(config.maxOpen)
+		db.SetMaxIdleConns(config.maxIdle)
+	}
+	graph := newGraph(db, config)
+	graph.connector = connector
+	return graph, nil
+}
+
+func newGraph(db *sql.DB, config *options) *Graph {
+	return &Graph{
+		db:         db,
+		stmts:      map[string]*sql.Stmt{},
+		readOnly:   config.readOnly,
+		canonical:  config.canonical,
+		undirected: config.undirected,
+		validator:  config.validator,
+		retries:    config.retries,
+		backoff:    config.backoff,
+	}
+}
+
+// FromDB wraps a SQLite handle the caller opened, e.g. one shared with the
+// rest of an application; Close then leaves it open. Options that set how
+// the database is opened, such as WithForeignKeys or WithWAL, are up to
+// whoever opened db, as is the size of its pool, and only the others take
+// effect here
+func FromDB(db *sql.DB, opts ...Option) *Graph {
+	graph := newGraph(db, newOptions(opts))
+	graph.borrowed = true
+	return graph
+}
+
+func NewInMemoryGraph() (*Graph, error) {
+	return NewGraph(IN_MEMORY)
+}
+
+// OpenReadOnly opens a handle whose write methods all fail with READ_ONLY,
+// on a connection SQLite itself will not write through either
+func OpenReadOnly(names ...string) (*Graph, error) {
+	return NewGraphWithOptions([]Option{WithReadOnly()}, names...)
+}
+
+// Open opens a database that Initialize has already set up, without running
+// any DDL; it fails if the file does not exist, has no schema yet, or has a
+// schema version other than the latest, which Migrate would bring up to date
+func Open(names ...string) (*Graph, error) {
+	graph, err := NewGraphWithOptions([]Option{mustExist()}, names...)
+	if err != nil {
+		return nil, err
+	}
+	version, err := graph.SchemaVersion()
+	if err == nil {
+		switch {
+		case version == 0:
+			err = errors.New(NO_SCHEMA)
+		case version < LatestSchemaVersion():
+			err = fmt.Errorf("database schema version %d is older than the latest version %d, call Migrate to upgrade it", version, LatestSchemaVersion())
+		case version > LatestSchemaVersion():
+			err = fmt.Errorf("database schema version %d is newer than the latest supported version %d", version, LatestSchemaVersion())
+		}
+	}
+	if err != nil {
+		graph.Close()
+		return nil, err
+	}
+	return graph, nil
+}
+
+func (g *Graph) canonicalJSON() bool {
+	return g.canonical
+}
+
+// checkNode refuses a body that is not JSON, or that the WithNodeValidator
+// function rejects
+func (g *Graph) checkNode(node []byte) error {
+	err := validateNode(node)
+	if err != nil || g.validator == nil {
+		return err
+	}
+	return g.validator(node)
+}
+
+func (g *Graph) undirectedEdges() bool {
+	return g.undirected
+}
+
+// edgeEnds puts a pair of ids in the order its edges are stored in, which on
+// an undirected graph is always the lesser id first
+func edgeEnds(q querier, sourceId string, targetId string) (string, string) {
+	if q.undirectedEdges() && targetId < sourceId {
+		return targetId, sourceId
+	}
+	return sourceId, targetId
+}
+
+// writable is checked ahead of every write, so a read-only graph refuses
+// the call before any statement is prepared
+func (g *Graph) writable() error {
+	if g.readOnly {
+		return errors.New(READ_ONLY)
+	}
+	return nil
+}
+
+// Close releases the statements and connections, or only the statements if
+// the handle came from FromDB; closing a graph that is already closed does
+// nothing
+func (g *Graph) Close() error {
+	g.mu.Lock()
+	if g.closed {
+		g.mu.Unlock()
+		return nil
+	}
+	g.closed = true
+	for statement, stmt := range g.stmts {
+		stmt.Close()
+		delete(g.stmts, statement)
+	}
+	g.mu.Unlock()
+	if g.borrowed {
+		return nil
+	}
+	return g.db.Close()
+}
+
+// SetForeignKeys turns enforcement of the edge to node references on or off
+// for every connection in the pool, e.g. to bulk load edges before their
+// nodes; a connection picks up the change the next time it is taken from
+// the pool, so statements and transactions already running keep the setting
+// they started with
+func (g *Graph) SetForeignKeys(on bool) error {
+	if g.connector == nil {
+		return errors.New("foreign keys on a database from FromDB are set by whoever opened it")
+	}
+	g.connector.setForeignKeys(on)
+	return nil
+}
+
+// Ping checks that the database can still be reached, opening a connection
+// if none is open
+func (g *Graph) Ping(ctx context.Context) error {
+	return g.db.PingContext(ctx)
+}
+
+func Ping(ctx context.Context, database ...string) error {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return err
+	}
+	defer graph.Close()
+	return graph.Ping(ctx)
+}
+
+// prepare returns the cached statement for this SQL text, preparing it on
+// first use; cached statements stay open until Close, so callers must not
+// close them
+func (g *Graph) prepare(ctx context.Context, statement string) (*sql.Stmt, error) {
+	g.mu.Lock()
+	stmt, ok := g.stmts[statement]
+	g.mu.Unlock()
+	if ok {
+		return stmt, nil
+	}
+
+	// prepare outside the lock, since it may have to wait for a connection
+	stmt, err := g.db.PrepareContext(ctx, statement)
+	if err != nil {
+		return nil, err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		// Close ran meanwhile and would never see this statement
+		stmt.Close()
+		return nil, errors.New("sql: database is closed")
+	}
+	if cached, ok := g.stmts[statement]; ok {
+		stmt.Close()
+		return cached, nil
+	}
+	g.stmts[statement] = stmt
+	return stmt, nil
+}
+
+// Vacuum rebuilds the database file to give back the space left by deletions
+func (g *Graph) Vacuum() error {
+	if err := g.writable(); err != nil {
+		return err
+	}
+	_, err := g.db.Exec(VacuumDatabase)
+	if err != nil {
+		return err
+	}
+
+	// VACUUM may renumber the node rowids the text index points at
+	indexed, err := g.hasTextSearch()
+	if err != nil || !indexed {
+		return err
+	}
+	_, err = g.db.Exec(RebuildTextSearch)
+	return err
+}
+
+func Vacuum(database ...string) error {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return err
+	}
+	defer graph.Close()
+	return graph.Vacuum()
+}
+
+// Analyze refreshes the statistics the query planner uses to pick indexes
+func (g *Graph) Analyze() error {
+	if err := g.writable(); err != nil {
+		return err
+	}
+	_, err := g.db.Exec(AnalyzeDatabase)
+	return err
+}
+
+func Analyze(database ...string) error {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return err
+	}
+	defer graph.Close()
+	return graph.Analyze()
+}
+
+// Checkpoint copies everything in the write-ahead log into the database file
+// and empties the log, e.g. before taking a snapshot of the file; without
+// WAL there is no log and it does nothing
+func (g *Graph) Checkpoint() error {
+	if err := g.writable(); err != nil {
+		return err
+	}
+	var busy, logged, checkpointed int
+	err := g.db.QueryRow(CheckpointDatabase).Scan(&busy, &logged, &checkpointed)
+	if err != nil {
+		return err
+	}
+	if busy != 0 {
+		return errors.New("checkpoint did not complete because another connection is using the database")
+	}
+	return nil
+}
+
+func Checkpoint(database ...string) error {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return err
+	}
+	defer graph.Close()
+	return graph.Checkpoint()
+}
+
+// ExplainQueryPlan shows how SQLite would run a statement, one line per step
+// of the plan with nested steps indented, e.g. to spot a full table scan
+func (g *Graph) ExplainQueryPlan(statement string, args []interface{}) ([]string, error) {
+	rows, err := g.db.Query(ExplainStatement+statement, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	depths := map[int]int{}
+	plan := []string{}
+	for rows.Next() {
+		var id, parent, unused int
+		var detail string
+		err = rows.Scan(&id, &parent, &unused, &detail)
+		if err != nil {
+			return nil, err
+		}
+		depth := 0
+		if parentDepth, ok := depths[parent]; ok {
+			depth = parentDepth + 1
+		}
+		depths[id] = depth
+		plan = append(plan, strings.Repeat("  ", depth)+detail)
+	}
+	return plan, rows.Err()
+}
+
+func ExplainQueryPlan(statement string, args []interface{}, database ...string) ([]string, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return nil, err
+	}
+	defer graph.Close()
+	return graph.ExplainQueryPlan(statement, args)
+}
+
+func execResult(ctx context.Context, q querier, statement string, args ...interface{}) (sql.Result, error) {
+	if err := q.writable(); err != nil {
+		return nil, err
+	}
+	stmt, stmtErr := q.prepare(ctx, statement)
+	if stmtErr != nil {
+		return nil, stmtErr
+	}
+	var result sql.Result
+	err := q.retry(ctx, func() error {
+		var err error
+		result, err = stmt.ExecContext(ctx, args...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func execAffected(ctx context.Context, q querier, statement string, args ...interface{}) (int64, error) {
+	result, err := execResult(ctx, q, statement, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,156 @@
+package simplegraph
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// rewireEdges moves every edge end at from over to to, leaving alone any
+// edge that would then become a loop between from and to; on an undirected
+// graph the moved edges are then put back in edgeEnds order, since to may
+// sort before the other end where from did not
+func rewireEdges(ctx context.Context, q querier, from string, to string) error {
+	_, err := execAffected(ctx, q, UpdateEdgeLoops, to, to, from, from)
+	if err != nil {
+		return err
+	}
+	_, err = execAffected(ctx, q, UpdateEdgeSources, to, from, to)
+	if err != nil {
+		return err
+	}
+	_, err = execAffected(ctx, q, UpdateEdgeTargets, to, from, to)
+	if err != nil || !q.undirectedEdges() {
+		return err
+	}
+	_, err = execAffected(ctx, q, UpdateEdgesIntoOrder, to, to)
+	return err
+}
+
+// mergeBodies adds the top level properties of absorb that keep lacks, in
+// key order, so keep's value wins whenever both have the same key
+func mergeBodies(keep string, absorb string) ([]byte, error) {
+	var kept map[string]json.RawMessage
+	err := json.Unmarshal([]byte(keep), &kept)
+	if err != nil {
+		return nil, err
+	}
+	var absorbed map[string]json.RawMessage
+	err = json.Unmarshal([]byte(absorb), &absorbed)
+	if err != nil {
+		return nil, err
+	}
+
+	extras := []string{}
+	for key := range absorbed {
+		if _, found := kept[key]; !found {
+			extras = append(extras, key)
+		}
+	}
+	sort.Strings(extras)
+
+	merged := []byte(keep)
+	for _, key := range extras {
+		merged, err = appendMember(merged, key, absorbed[key])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return merged, nil
+}
+
+// MergeNodes folds absorb into keep: absorb's edges are moved over to keep,
+// edges between the two are dropped rather than turned into loops, and
+// absorb is deleted, all in one transaction; on an undirected graph an edge
+// of absorb's is dropped too if keep already has one with the same node
+func (g *Graph) MergeNodes(keep string, absorb string) error {
+	if keep == absorb {
+		return errors.New("cannot merge a node into itself")
+	}
+	if err := g.writable(); err != nil {
+		return err
+	}
+	return g.WithTransaction(func(tx *Tx) error {
+		keepBody, err := findNode(tx.ctx, tx, keep)
+		if err != nil {
+			return err
+		}
+		absorbBody, err := findNode(tx.ctx, tx, absorb)
+		if err != nil {
+			return err
+		}
+		merged, err := mergeBodies(keepBody, absorbBody)
+		if err != nil {
+			return err
+		}
+		_, err = updateNode(tx.ctx, tx, keep, merged)
+		if err != nil {
+			return err
+		}
+
+		if tx.undirectedEdges() {
+			// each pair is stored once, so moving both edges would repeat it
+			_, err = execAffected(tx.ctx, tx, DeleteMergedDuplicates, absorb, keep)
+			if err != nil {
+				return err
+			}
+		}
+		err = rewireEdges(tx.ctx, tx, absorb, keep)
+		if err != nil {
+			return err
+		}
+		_, err = deleteNodes(tx, []string{absorb})
+		return err
+	})
+}
+
+func MergeNodes(keep string, absorb string, database ...string) error {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return err
+	}
+	defer graph.Close()
+	return graph.MergeNodes(keep, absorb)
+}
+
+// RenameNode gives a node a new id, in its body and on every edge, in one
+// transaction; foreign keys are only checked at commit, once the edges
+// point at the new id
+func (g *Graph) RenameNode(oldId string, newId string) error {
+	if err := g.writable(); err != nil {
+		return err
+	}
+	return g.WithTransaction(func(tx *Tx) error {
+		exists, err := nodeExists(tx.ctx, tx, newId)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("node %q already exists", newId)
+		}
+		_, err = execAffected(tx.ctx, tx, DeferForeignKeys)
+		if err != nil {
+			return err
+		}
+		count, err := execAffected(tx.ctx, tx, UpdateNodeId, newId, oldId)
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return notFoundError{oldId, sql.ErrNoRows}
+		}
+		return rewireEdges(tx.ctx, tx, oldId, newId)
+	})
+}
+
+func RenameNode(oldId string, newId string, database ...string) error {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return err
+	}
+	defer graph.Close()
+	return graph.RenameNode(oldId, newId)
+}
@@ -0,0 +1,155 @@
+package simplegraph
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestMergeNodes(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	AddNodes([]string{"1", "2", "3", "22"},
+		[][]byte{[]byte(apple), []byte(woz), []byte(jobs), []byte(`{"name":"Woz","nickname":"Woz","born":1950}`)}, file)
+	ConnectNodesWithProperties("2", "1", []byte(founded), file)
+	ConnectNodesWithProperties("22", "1", []byte(divested), file)
+	ConnectNodes("3", "22", file)
+	ConnectNodes("22", "2", file)
+	ConnectNodes("2", "22", file)
+	ConnectNodes("22", "22", file)
+
+	err := MergeNodes("2", "22", file)
+	if err != nil {
+		t.Fatalf("MergeNodes() produced an error %q but expected nil", err.Error())
+	}
+
+	node, _ := FindNode("2", file)
+	expected := `{"id":"2","name":"Steve Wozniak","type":["person","engineer","founder"],"born":1950,"nickname":"Woz"}`
+	if node != expected {
+		t.Errorf("MergeNodes() left %q but expected %q", node, expected)
+	}
+	exists, _ := NodeExists("22", file)
+	if exists {
+		t.Errorf("MergeNodes() kept the absorbed node")
+	}
+
+	edges, _ := ListEdges(0, 0, file)
+	expectedEdges := []EdgeData{{"2", "1", founded}, {"2", "1", divested}, {"3", "2", `{}`}, {"2", "2", `{}`}}
+	if fmt.Sprint(edges) != fmt.Sprint(expectedEdges) {
+		t.Errorf("MergeNodes() left edges %v but expected %v", edges, expectedEdges)
+	}
+
+	err = MergeNodes("2", "99", file)
+	expectedErr := `node "99" not found: ` + NO_ROWS_FOUND
+	if !ErrorMatches(err, expectedErr) {
+		t.Errorf("MergeNodes() produced %v but expected %q", err, expectedErr)
+	}
+	err = MergeNodes("2", "2", file)
+	if err == nil {
+		t.Errorf("MergeNodes() produced nil but expected an error merging a node into itself")
+	}
+}
+
+func TestMergeNodesUndirected(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	graph, _ := NewGraphWithOptions([]Option{WithUndirectedEdges()}, file)
+	defer graph.Close()
+	graph.AddNodes([]string{"a", "b", "c", "z"}, [][]byte{[]byte(`{}`), []byte(`{}`), []byte(`{}`), []byte(`{}`)})
+	graph.ConnectNodesWithProperties("a", "b", []byte(founded))
+	graph.ConnectNodesWithProperties("a", "z", []byte(divested))
+	graph.ConnectNodes("b", "z")
+	graph.ConnectNodes("z", "c")
+	graph.ConnectNodes("z", "z")
+
+	err := graph.MergeNodes("b", "z")
+	if err != nil {
+		t.Fatalf("MergeNodes() produced an error %q but expected nil", err.Error())
+	}
+	edges, _ := graph.ListEdges(0, 0)
+	expected := []EdgeData{{"a", "b", founded}, {"b", "c", `{}`}, {"b", "b", `{}`}}
+	if fmt.Sprint(edges) != fmt.Sprint(expected) {
+		t.Errorf("MergeNodes() left edges %v but expected %v", edges, expected)
+	}
+	between, _ := graph.GetEdgesBetween("b", "a")
+	if len(between) != 1 {
+		t.Errorf("GetEdgesBetween() after MergeNodes() produced %v but expected one edge", between)
+	}
+}
+
+func TestRenameNode(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	AddNodes([]string{"1", "2", "3"}, [][]byte{[]byte(apple), []byte(woz), []byte(jobs)}, file)
+	ConnectNodesWithProperties("2", "1", []byte(founded), file)
+	ConnectNodesWithProperties("1", "3", []byte(divested), file)
+	ConnectNodes("1", "1", file)
+
+	err := RenameNode("1", "apple", file)
+	if err != nil {
+		t.Fatalf("RenameNode() produced an error %q but expected nil", err.Error())
+	}
+	node, err := FindNode("apple", file)
+	expected := `{"name":"Apple Computer Company","type":["company","start-up"],"founded":"April 1, 1976","id":"apple"}`
+	if node != expected || err != nil {
+		t.Errorf("FindNode() after RenameNode() produced %q,%v but expected %q,nil", node, err, expected)
+	}
+	exists, _ := NodeExists("1", file)
+	if exists {
+		t.Errorf("RenameNode() kept the old id")
+	}
+	edges, _ := ListEdges(0, 0, file)
+	expectedEdges := []EdgeData{{"2", "apple", founded}, {"apple", "3", divested}, {"apple", "apple", `{}`}}
+	if fmt.Sprint(edges) != fmt.Sprint(expectedEdges) {
+		t.Errorf("RenameNode() left edges %v but expected %v", edges, expectedEdges)
+	}
+
+	err = RenameNode("apple", "2", file)
+	if !ErrorMatches(err, `node "2" already exists`) {
+		t.Errorf("RenameNode() produced %v but expected an error for an existing id", err)
+	}
+	err = RenameNode("99", "100", file)
+	if !ErrorMatches(err, `node "99" not found: `+NO_ROWS_FOUND) {
+		t.Errorf("RenameNode() produced %v but expected an error for a missing node", err)
+	}
+}
+
+func TestRenameNodeUndirected(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	graph, _ := NewGraphWithOptions([]Option{WithUndirectedEdges()}, file)
+	defer graph.Close()
+	graph.AddNodes([]string{"a", "c", "d"}, [][]byte{[]byte(`{}`), []byte(`{}`), []byte(`{}`)})
+	graph.ConnectNodesWithProperties("a", "c", []byte(founded))
+	graph.ConnectNodes("c", "d")
+
+	err := graph.RenameNode("c", "0")
+	if err != nil {
+		t.Fatalf("RenameNode() produced an error %q but expected nil", err.Error())
+	}
+	edges, _ := graph.ListEdges(0, 0)
+	expected := []EdgeData{{"0", "a", founded}, {"0", "d", `{}`}}
+	if fmt.Sprint(edges) != fmt.Sprint(expected) {
+		t.Errorf("RenameNode() left edges %v but expected %v", edges, expected)
+	}
+	between, err := graph.GetEdgesBetween("a", "0")
+	if len(between) != 1 || err != nil {
+		t.Errorf("GetEdgesBetween() after RenameNode() produced %v,%v but expected the edge", between, err)
+	}
+	count, err := graph.UpdateEdgeProperties("a", "0", []byte(divested))
+	if count != 1 || err != nil {
+		t.Errorf("UpdateEdgeProperties() after RenameNode() produced %d,%v but expected 1,nil", count, err)
+	}
+	count, err = graph.RemoveEdge("a", "0")
+	if count != 1 || err != nil {
+		t.Errorf("RemoveEdge() after RenameNode() produced %d,%v but expected 1,nil", count, err)
+	}
+}
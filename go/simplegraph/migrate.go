@@ -0,0 +1,83 @@
+package simplegraph
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// migrations holds the schema changes in the order they were made: applying
+// the step at index i takes a database from version i to version i+1, so new
+// steps are only ever appended
+var migrations = []string{
+	Schema,
+}
+
+func LatestSchemaVersion() int {
+	return len(migrations)
+}
+
+func (g *Graph) SchemaVersion() (int, error) {
+	version, err := g.queryCount(SearchSchemaVersion)
+	return int(version), err
+}
+
+func SchemaVersion(database ...string) (int, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return 0, err
+	}
+	defer graph.Close()
+	return graph.SchemaVersion()
+}
+
+func (t *Tx) execScript(script string) error {
+	for _, statement := range strings.Split(script, ";") {
+		sql := strings.TrimSpace(statement)
+		if len(sql) > 0 {
+			if _, err := t.tx.ExecContext(t.ctx, sql); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Migrate brings the schema up to the latest version, applying each pending
+// step in its own transaction along with the version it leads to
+func (g *Graph) Migrate() error {
+	current, err := g.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if current > LatestSchemaVersion() {
+		return fmt.Errorf("database schema version %d is newer than the latest supported version %d", current, LatestSchemaVersion())
+	}
+	if current < LatestSchemaVersion() {
+		if err := g.writable(); err != nil {
+			return err
+		}
+	}
+	for version := current + 1; version <= LatestSchemaVersion(); version++ {
+		err = g.WithTransaction(func(tx *Tx) error {
+			if err := tx.execScript(migrations[version-1]); err != nil {
+				return err
+			}
+			_, err := tx.tx.ExecContext(tx.ctx, UpdateSchemaVersion+strconv.Itoa(version))
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migration to schema version %d: %w", version, err)
+		}
+	}
+	return nil
+}
+
+func Migrate(database ...string) error {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return err
+	}
+	defer graph.Close()
+	return graph.Migrate()
+}
@@ -0,0 +1,177 @@
+package simplegraph
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestInitializeSetsSchemaVersion(t *testing.T) {
+	file := "testdb.sqlite3"
+	defer os.Remove(file)
+
+	version, err := SchemaVersion(file)
+	if version != 0 || err != nil {
+		t.Errorf("SchemaVersion() produced %d,%v but expected 0,nil", version, err)
+	}
+
+	err = Initialize(file)
+	if err != nil {
+		t.Fatalf("Initialize() produced an error %q but expected nil", err.Error())
+	}
+	version, err = SchemaVersion(file)
+	if version != LatestSchemaVersion() || err != nil {
+		t.Errorf("SchemaVersion() produced %d,%v but expected %d,nil", version, err, LatestSchemaVersion())
+	}
+}
+
+func TestInitializeTwice(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+	AddNode("1", []byte(apple), file)
+
+	err := Initialize(file)
+	if err != nil {
+		t.Fatalf("Initialize() on an initialized database produced an error %q but expected nil", err.Error())
+	}
+
+	// databases made before the schema was versioned still report version 0
+	graph, _ := NewGraph(file)
+	defer graph.Close()
+	graph.db.Exec(UpdateSchemaVersion + "0")
+	err = graph.Initialize()
+	if err != nil {
+		t.Fatalf("Initialize() on an unversioned database produced an error %q but expected nil", err.Error())
+	}
+
+	node, err := graph.FindNode("1")
+	if node != apple || err != nil {
+		t.Errorf("FindNode() after Initialize() produced %q,%v but expected %q,nil", node, err, apple)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+	AddNode("1", []byte(apple), file)
+
+	defer func(original []string) { migrations = original }(migrations)
+	migrations = append(migrations,
+		"CREATE TABLE IF NOT EXISTS labels (name TEXT);\nINSERT INTO labels VALUES ('first')",
+		"INSERT INTO labels VALUES ('second')",
+	)
+
+	err := Migrate(file)
+	if err != nil {
+		t.Fatalf("Migrate() produced an error %q but expected nil", err.Error())
+	}
+	version, err := SchemaVersion(file)
+	if version != LatestSchemaVersion() || err != nil {
+		t.Errorf("SchemaVersion() produced %d,%v but expected %d,nil", version, err, LatestSchemaVersion())
+	}
+
+	// migrating again must not re-run the steps already applied
+	err = Migrate(file)
+	if err != nil {
+		t.Fatalf("Migrate() produced an error %q but expected nil", err.Error())
+	}
+	graph, _ := NewGraph(file)
+	defer graph.Close()
+	labels, err := graph.queryCount("SELECT count(*) FROM labels")
+	if labels != 2 || err != nil {
+		t.Errorf("Migrate() left %d,%v labels but expected 2,nil", labels, err)
+	}
+	node, err := graph.FindNode("1")
+	if node != apple || err != nil {
+		t.Errorf("FindNode() after Migrate() produced %q,%v but expected %q,nil", node, err, apple)
+	}
+}
+
+func TestMigrateFailedStep(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	defer func(original []string) { migrations = original }(migrations)
+	migrations = append(migrations, "CREATE TABLE labels (name TEXT);\nINSERT INTO missing VALUES (1)")
+
+	expected := fmt.Sprintf("migration to schema version %d: no such table: missing", LatestSchemaVersion())
+	err := Migrate(file)
+	if !ErrorMatches(err, expected) {
+		t.Errorf("Migrate() produced %v but expected %q", err, expected)
+	}
+
+	// the failed step rolled back entirely, version included
+	version, _ := SchemaVersion(file)
+	if version != LatestSchemaVersion()-1 {
+		t.Errorf("SchemaVersion() produced %d but expected %d", version, LatestSchemaVersion()-1)
+	}
+	graph, _ := NewGraph(file)
+	defer graph.Close()
+	_, err = graph.queryCount("SELECT count(*) FROM labels")
+	if !ErrorMatches(err, "no such table: labels") {
+		t.Errorf("Migrate() kept the labels table from a failed step: %v", err)
+	}
+}
+
+func TestMigrateNewerDatabase(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	graph, _ := NewGraph(file)
+	defer graph.Close()
+	newer := LatestSchemaVersion() + 1
+	graph.db.Exec(UpdateSchemaVersion + strconv.Itoa(newer))
+
+	expected := fmt.Sprintf("database schema version %d is newer than the latest supported version %d", newer, LatestSchemaVersion())
+	err := graph.Migrate()
+	if !ErrorMatches(err, expected) {
+		t.Errorf("Migrate() produced %v but expected %q", err, expected)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	file := "testdb.sqlite3"
+	defer os.Remove(file)
+
+	graph, err := Open(file)
+	if graph != nil || err == nil {
+		t.Errorf("Open() on a missing file produced %v,%v but expected nil and an error", graph, err)
+	}
+	if _, statErr := os.Stat(file); !os.IsNotExist(statErr) {
+		t.Errorf("Open() on a missing file created it")
+	}
+
+	empty, _ := NewGraph(file)
+	empty.Ping(context.Background())
+	empty.Close()
+	_, err = Open(file)
+	if !ErrorMatches(err, NO_SCHEMA) {
+		t.Errorf("Open() on an empty database produced %v but expected %q", err, NO_SCHEMA)
+	}
+
+	Initialize(file)
+	AddNode("1", []byte(apple), file)
+	graph, err = Open(file)
+	if err != nil {
+		t.Fatalf("Open() produced an error %q but expected nil", err.Error())
+	}
+	defer graph.Close()
+	node, err := graph.FindNode("1")
+	if node != apple || err != nil {
+		t.Errorf("FindNode() after Open() produced %q,%v but expected %q,nil", node, err, apple)
+	}
+
+	defer func(original []string) { migrations = original }(migrations)
+	migrations = append(migrations, "CREATE TABLE labels (name TEXT)")
+	expected := fmt.Sprintf("database schema version %d is older than the latest version %d, call Migrate to upgrade it", LatestSchemaVersion()-1, LatestSchemaVersion())
+	_, err = Open(file)
+	if !ErrorMatches(err, expected) {
+		t.Errorf("Open() on an outdated database produced %v but expected %q", err, expected)
+	}
+}
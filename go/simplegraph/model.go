@@ -0,0 +1,135 @@
+package simplegraph
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// Node is a node's id together with its JSON body, as GetNode and
+// GetNeighborNodes return them
+type Node struct {
+	ID   string
+	Body json.RawMessage
+}
+
+// Edge is an edge between two node ids, with its JSON properties; unlike
+// EdgeData, the properties are JSON rather than a string to parse again
+type Edge struct {
+	Source     string
+	Target     string
+	Properties json.RawMessage
+}
+
+func newEdge(edge EdgeData) Edge {
+	return Edge{Source: edge.Source, Target: edge.Target, Properties: json.RawMessage(edge.Label)}
+}
+
+// Unmarshal decodes the body into v, as json.Unmarshal does
+func (n Node) Unmarshal(v interface{}) error {
+	return json.Unmarshal(n.Body, v)
+}
+
+// Get looks up one value in the body by a dotted path of object keys and
+// array indexes, e.g. "address.city" or "type.0"; it reports false if the
+// path leads nowhere, and an empty path returns the whole body
+func (n Node) Get(path string) (json.RawMessage, bool) {
+	value := n.Body
+	if path == "" {
+		return value, true
+	}
+	for _, step := range strings.Split(path, ".") {
+		var members map[string]json.RawMessage
+		if json.Unmarshal(value, &members) == nil && members != nil {
+			member, ok := members[step]
+			if !ok {
+				return nil, false
+			}
+			value = member
+			continue
+		}
+		var elements []json.RawMessage
+		index, err := strconv.Atoi(step)
+		if err != nil || json.Unmarshal(value, &elements) != nil || index < 0 || index >= len(elements) {
+			return nil, false
+		}
+		value = elements[index]
+	}
+	return value, true
+}
+
+// Unmarshal decodes the properties into v, as json.Unmarshal does
+func (e Edge) Unmarshal(v interface{}) error {
+	return json.Unmarshal(e.Properties, v)
+}
+
+// GetNode is FindNode returning a Node, with the same ErrNodeNotFound error
+// when there is no node with that id
+func (g *Graph) GetNode(identifier string) (Node, error) {
+	body, err := g.FindNode(identifier)
+	if err != nil {
+		return Node{}, err
+	}
+	return Node{ID: identifier, Body: json.RawMessage(body)}, nil
+}
+
+func GetNode(identifier string, database ...string) (Node, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return Node{}, err
+	}
+	defer graph.Close()
+	return graph.GetNode(identifier)
+}
+
+// GetNeighborNodes returns the nodes GetNeighbors finds, in the same order,
+// bodies included
+func (g *Graph) GetNeighborNodes(identifier string) ([]Node, error) {
+	ids, err := g.GetNeighbors(identifier)
+	if err != nil {
+		return nil, err
+	}
+	bodies, err := g.FindNodesByIds(ids)
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]Node, 0, len(ids))
+	for _, id := range ids {
+		// with foreign keys off, an edge may name a node that is gone
+		if body, ok := bodies[id]; ok {
+			nodes = append(nodes, Node{ID: id, Body: json.RawMessage(body)})
+		}
+	}
+	return nodes, nil
+}
+
+func GetNeighborNodes(identifier string, database ...string) ([]Node, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return nil, err
+	}
+	defer graph.Close()
+	return graph.GetNeighborNodes(identifier)
+}
+
+// GetEdges returns the edges Connections finds, to or from the node, as Edges
+func (g *Graph) GetEdges(identifier string) ([]Edge, error) {
+	connections, err := g.Connections(identifier)
+	if err != nil {
+		return nil, err
+	}
+	edges := make([]Edge, 0, len(connections))
+	for _, edge := range connections {
+		edges = append(edges, newEdge(edge))
+	}
+	return edges, nil
+}
+
+func GetEdges(identifier string, database ...string) ([]Edge, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return nil, err
+	}
+	defer graph.Close()
+	return graph.GetEdges(identifier)
+}
@@ -0,0 +1,77 @@
+package simplegraph
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestNodeGet(t *testing.T) {
+	node := Node{ID: "1", Body: json.RawMessage(`{"id":"1","name":"Apple","type":["company","start-up"],"address":{"city":"Cupertino"}}`)}
+	for _, test := range []struct {
+		path     string
+		expected string
+		found    bool
+	}{
+		{"name", `"Apple"`, true},
+		{"address.city", `"Cupertino"`, true},
+		{"type.1", `"start-up"`, true},
+		{"type", `["company","start-up"]`, true},
+		{"", string(node.Body), true},
+		{"founded", "", false},
+		{"type.2", "", false},
+		{"type.first", "", false},
+		{"name.first", "", false},
+		{"address.city.zip", "", false},
+	} {
+		value, found := node.Get(test.path)
+		if string(value) != test.expected || found != test.found {
+			t.Errorf("Get(%q) produced %s,%v but expected %s,%v", test.path, value, found, test.expected, test.found)
+		}
+	}
+}
+
+func TestNodesAndEdges(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+	AddNodes([]string{"1", "2", "3"}, [][]byte{[]byte(apple), []byte(woz), []byte(jobs)}, file)
+	ConnectNodesWithProperties("2", "1", []byte(founded), file)
+	ConnectNodesWithProperties("3", "1", []byte(founded), file)
+
+	node, err := GetNode("2", file)
+	if node.ID != "2" || string(node.Body) != woz || err != nil {
+		t.Errorf("GetNode() produced %v,%v but expected the body of 2", node, err)
+	}
+	var person struct {
+		Name string   `json:"name"`
+		Type []string `json:"type"`
+	}
+	err = node.Unmarshal(&person)
+	if person.Name != "Steve Wozniak" || len(person.Type) != 3 || err != nil {
+		t.Errorf("Unmarshal() produced %v,%v but expected Steve Wozniak", person, err)
+	}
+	_, err = GetNode("99", file)
+	if !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("GetNode() on a missing node produced %v but expected %v", err, ErrNodeNotFound)
+	}
+
+	neighbors, err := GetNeighborNodes("1", file)
+	if len(neighbors) != 2 || err != nil {
+		t.Fatalf("GetNeighborNodes() produced %v,%v but expected nodes 2 and 3", neighbors, err)
+	}
+	if neighbors[0].ID != "2" || string(neighbors[0].Body) != woz || neighbors[1].ID != "3" || string(neighbors[1].Body) != jobs {
+		t.Errorf("GetNeighborNodes() produced %v but expected nodes 2 and 3 with their bodies", neighbors)
+	}
+
+	edges, err := GetEdges("2", file)
+	if len(edges) != 1 || edges[0].Source != "2" || edges[0].Target != "1" || err != nil {
+		t.Fatalf("GetEdges() produced %v,%v but expected the edge from 2 to 1", edges, err)
+	}
+	var properties map[string]string
+	err = edges[0].Unmarshal(&properties)
+	if properties["action"] != "founded" || err != nil {
+		t.Errorf("Unmarshal() produced %v,%v but expected the founded action", properties, err)
+	}
+}
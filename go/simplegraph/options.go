@@ -0,0 +1,191 @@
+package simplegraph
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DEFAULT_BUSY_TIMEOUT is how long a locked database is retried unless
+// WithBusyTimeout says otherwise
+const DEFAULT_BUSY_TIMEOUT = 5 * time.Second
+
+// DEFAULT_MAX_IDLE_CONNS is how many idle connections the pool keeps unless
+// WithMaxIdleConns says otherwise, the same as database/sql's own default
+const DEFAULT_MAX_IDLE_CONNS = 2
+
+// Option adjusts how NewGraphWithOptions opens the database
+type Option func(*options)
+
+type options struct {
+	foreignKeys bool
+	busyTimeout time.Duration
+	journalMode string
+	readOnly    bool
+	mustExist   bool
+	canonical   bool
+	undirected  bool
+	validator   func(body []byte) error
+	retries     int
+	backoff     time.Duration
+	maxOpen     int
+	maxIdle     int
+}
+
+func newOptions(opts []Option) *options {
+	config := &options{
+		foreignKeys: true,
+		busyTimeout: DEFAULT_BUSY_TIMEOUT,
+		retries:     DEFAULT_RETRIES,
+		backoff:     DEFAULT_RETRY_BACKOFF,
+		maxIdle:     DEFAULT_MAX_IDLE_CONNS,
+	}
+	for _, opt := range opts {
+		opt(config)
+	}
+	return config
+}
+
+// WithForeignKeys turns enforcement of the edge to node references on or
+// off; it is on unless this says otherwise
+func WithForeignKeys(enabled bool) Option {
+	return func(o *options) {
+		o.foreignKeys = enabled
+	}
+}
+
+// WithBusyTimeout makes SQLite keep retrying a locked database for up to d
+// before it gives up with "database is locked"; zero gives up at once
+func WithBusyTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.busyTimeout = d
+	}
+}
+
+// WithRetry sets how many more times a write is tried when the database
+// is still locked once the busy timeout runs out, waiting backoff before the
+// first retry and twice as long before each one after that
+func WithRetry(retries int, backoff time.Duration) Option {
+	return func(o *options) {
+		o.retries = retries
+		o.backoff = backoff
+	}
+}
+
+// WithJournalMode sets one of SQLite's journal modes, e.g. DELETE, TRUNCATE
+// or WAL
+func WithJournalMode(mode string) Option {
+	return func(o *options) {
+		o.journalMode = mode
+	}
+}
+
+// WithWAL switches the database to write-ahead logging, so readers are no
+// longer blocked by a writer; SQLite keeps -wal and -shm files next to the
+// database file while it is open
+func WithWAL() Option {
+	return WithJournalMode("WAL")
+}
+
+// WithReadOnly opens an existing database without permission to change it
+func WithReadOnly() Option {
+	return func(o *options) {
+		o.readOnly = true
+	}
+}
+
+// mustExist makes opening fail rather than create the file if there is no
+// database there yet
+func mustExist() Option {
+	return func(o *options) {
+		o.mustExist = true
+	}
+}
+
+// WithCanonicalJSON stores every node body in one canonical form, compact
+// with the members of each object sorted by name, so equal bodies are
+// always stored byte for byte the same however they were written
+func WithCanonicalJSON() Option {
+	return func(o *options) {
+		o.canonical = true
+	}
+}
+
+// WithUndirectedEdges treats every edge as going both ways: each edge is
+// stored once with the lesser id as its source, connecting a pair that is
+// already connected in either order adds nothing, and the neighbor, degree
+// and traversal methods follow edges from either end
+func WithUndirectedEdges() Option {
+	return func(o *options) {
+		o.undirected = true
+	}
+}
+
+// WithNodeValidator has every node body checked by fn before it is written,
+// once it is known to be valid JSON; when fn returns an error the write is
+// refused with that error, so fn can apply a JSON Schema or any other rule
+func WithNodeValidator(fn func(body []byte) error) Option {
+	return func(o *options) {
+		o.validator = fn
+	}
+}
+
+// WithMaxOpenConns caps how many connections the pool opens at once; zero,
+// the default, leaves it unlimited. One suits a database with several
+// writers, which then queue in Go rather than wait on SQLite's write lock,
+// while WAL readers gain from more. An in-memory graph always has one
+func WithMaxOpenConns(n int) Option {
+	return func(o *options) {
+		o.maxOpen = n
+	}
+}
+
+// WithMaxIdleConns sets how many connections the pool keeps open while they
+// are not in use, DEFAULT_MAX_IDLE_CONNS unless this says otherwise
+func WithMaxIdleConns(n int) Option {
+	return func(o *options) {
+		o.maxIdle = n
+	}
+}
+
+func (o *options) params() []string {
+	params := []string{
+		fmt.Sprintf("_foreign_keys=%t", o.foreignKeys),
+		fmt.Sprintf("_busy_timeout=%d", o.busyTimeout.Milliseconds()),
+	}
+	if len(o.journalMode) > 0 {
+		params = append(params, "_journal_mode="+o.journalMode)
+	}
+	return params
+}
+
+func (o *options) reference(names ...string) (string, error) {
+	var path string
+	switch len(names) {
+	case 1:
+		path = names[0]
+	case 2:
+		path = filepath.Join(names[0], names[1])
+	default:
+		return "", errors.New("invalid database file reference")
+	}
+
+	params := o.params()
+	if path == IN_MEMORY {
+		// without a shared cache each graph's database is its own, and lasts
+		// as long as the one connection its pool is pinned to
+		return IN_MEMORY_REFERENCE + "?" + strings.Join(params, "&"), nil
+	}
+	if o.readOnly || o.mustExist {
+		// the driver only hands the mode on to SQLite for file: URIs
+		mode := "mode=rw"
+		if o.readOnly {
+			mode = "mode=ro"
+		}
+		path = "file:" + path
+		params = append([]string{mode}, params...)
+	}
+	return path + "?" + strings.Join(params, "&"), nil
+}
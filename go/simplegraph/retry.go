@@ -0,0 +1,45 @@
+package simplegraph
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// DEFAULT_RETRIES and DEFAULT_RETRY_BACKOFF apply unless WithRetry says
+// otherwise; the backoff doubles after every attempt
+const (
+	DEFAULT_RETRIES       = 3
+	DEFAULT_RETRY_BACKOFF = 50 * time.Millisecond
+)
+
+// isTransient reports whether err only means another connection held the
+// lock at the time, so the same operation may well succeed if tried again
+func isTransient(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+	}
+	return err != nil && strings.Contains(err.Error(), "database is locked")
+}
+
+// retry runs op until it succeeds, fails with an error that is not
+// transient, or has been tried once more than the configured retries
+func (g *Graph) retry(ctx context.Context, op func() error) error {
+	backoff := g.backoff
+	for attempt := 0; ; attempt++ {
+		err := op()
+		if !isTransient(err) || attempt >= g.retries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,109 @@
+package simplegraph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// holdWriteLock takes the write lock on file and releases it after hold
+func holdWriteLock(t *testing.T, file string, hold time.Duration) func() {
+	locker, err := NewGraphWithOptions([]Option{WithBusyTimeout(0)}, file)
+	if err != nil {
+		t.Fatalf("NewGraphWithOptions() produced an error %q but expected nil", err.Error())
+	}
+	ctx := context.Background()
+	conn, err := locker.db.Conn(ctx)
+	if err != nil {
+		t.Fatalf("Conn() produced an error %q but expected nil", err.Error())
+	}
+	_, err = conn.ExecContext(ctx, "BEGIN IMMEDIATE")
+	if err != nil {
+		t.Fatalf("BEGIN IMMEDIATE produced an error %q but expected nil", err.Error())
+	}
+	released := make(chan struct{})
+	go func() {
+		time.Sleep(hold)
+		conn.ExecContext(ctx, "COMMIT")
+		conn.Close()
+		close(released)
+	}()
+	return func() {
+		<-released
+		locker.Close()
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	for err, expected := range map[error]bool{
+		nil:                                    false,
+		sqlite3.Error{Code: sqlite3.ErrBusy}:   true,
+		sqlite3.Error{Code: sqlite3.ErrLocked}: true,
+		fmt.Errorf("node 3: %w", sqlite3.Error{Code: sqlite3.ErrBusy}): true,
+		sqlite3.Error{Code: sqlite3.ErrConstraint}:                     false,
+		errors.New("database is locked"):                               true,
+		errors.New(UNIQUE_ID_CONSTRAINT):                               false,
+	} {
+		if isTransient(err) != expected {
+			t.Errorf("isTransient(%v) produced %t but expected %t", err, !expected, expected)
+		}
+	}
+}
+
+func TestRetryOnLockedDatabase(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	impatient, _ := NewGraphWithOptions([]Option{WithBusyTimeout(0), WithRetry(0, 0)}, file)
+	defer impatient.Close()
+	release := holdWriteLock(t, file, 200*time.Millisecond)
+	_, err := impatient.AddNode("1", []byte(apple))
+	if !isTransient(err) {
+		t.Errorf("AddNode() without retries produced %v but expected the database to be locked", err)
+	}
+	release()
+
+	// 20 + 40 + 80 + 160ms of backoff outlasts the lock
+	patient, _ := NewGraphWithOptions([]Option{WithBusyTimeout(0), WithRetry(6, 20*time.Millisecond)}, file)
+	defer patient.Close()
+	release = holdWriteLock(t, file, 200*time.Millisecond)
+	count, err := patient.AddNode("1", []byte(apple))
+	if count != 1 || err != nil {
+		t.Errorf("AddNode() with retries inserted %d,%v but expected 1,nil", count, err)
+	}
+	release()
+
+	release = holdWriteLock(t, file, 200*time.Millisecond)
+	count, err = patient.UpdateNodesWhere("id = ?", []interface{}{"1"}, []byte(`{"status":"archived"}`))
+	if count != 1 || err != nil {
+		t.Errorf("UpdateNodesWhere() with retries updated %d,%v but expected 1,nil", count, err)
+	}
+	release()
+
+	release = holdWriteLock(t, file, 200*time.Millisecond)
+	attempts := 0
+	err = patient.WithTransaction(func(tx *Tx) error {
+		attempts++
+		_, err := tx.AddNode("2", []byte(woz))
+		return err
+	})
+	if attempts < 2 || err != nil {
+		t.Errorf("WithTransaction() took %d attempt(s),%v but expected it to be retried until it succeeded", attempts, err)
+	}
+	release()
+
+	// a constraint violation is not worth another attempt
+	slow, _ := NewGraphWithOptions([]Option{WithRetry(5, time.Second)}, file)
+	defer slow.Close()
+	start := time.Now()
+	_, err = slow.AddNode("1", []byte(apple))
+	if !ErrorMatches(err, UNIQUE_ID_CONSTRAINT) || time.Since(start) > 500*time.Millisecond {
+		t.Errorf("AddNode() produced %v after %v but expected %q at once", err, time.Since(start), UNIQUE_ID_CONSTRAINT)
+	}
+}
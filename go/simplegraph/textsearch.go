@@ -0,0 +1,60 @@
+package simplegraph
+
+import (
+	"errors"
+	"strings"
+)
+
+func (g *Graph) hasTextSearch() (bool, error) {
+	found, err := g.queryStrings(SearchTextIndex)
+	return len(found) > 0, err
+}
+
+// EnableTextSearch builds a full-text index over the node bodies, which
+// triggers then keep in step with every insert, update and delete; it only
+// works when SQLite was compiled with FTS5 (the sqlite_fts5 build tag)
+func (g *Graph) EnableTextSearch() error {
+	if err := g.writable(); err != nil {
+		return err
+	}
+	return g.WithTransaction(func(tx *Tx) error {
+		_, err := tx.tx.ExecContext(tx.ctx, CreateTextSearch)
+		if err != nil {
+			if strings.Contains(err.Error(), "no such module: fts5") {
+				return errors.New(NO_TEXT_SEARCH)
+			}
+			return err
+		}
+		// index the nodes that were already there
+		_, err = tx.tx.ExecContext(tx.ctx, RebuildTextSearch)
+		return err
+	})
+}
+
+func EnableTextSearch(database ...string) error {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return err
+	}
+	defer graph.Close()
+	return graph.EnableTextSearch()
+}
+
+// SearchText returns the bodies of the nodes matching an FTS5 query, best
+// matches first
+func (g *Graph) SearchText(query string) ([]string, error) {
+	results, err := g.queryStrings(SearchNodesByText, query)
+	if err != nil && strings.Contains(err.Error(), "no such table: nodes_fts") {
+		return nil, errors.New(TEXT_SEARCH_DISABLED)
+	}
+	return results, err
+}
+
+func SearchText(query string, database ...string) ([]string, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return nil, err
+	}
+	defer graph.Close()
+	return graph.SearchText(query)
+}
@@ -0,0 +1,65 @@
+package simplegraph
+
+import (
+	"os"
+	"testing"
+)
+
+func TestSearchText(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+	AddNode("1", []byte(apple), file)
+	AddNode("2", []byte(woz), file)
+
+	graph, _ := NewGraph(file)
+	defer graph.Close()
+
+	_, err := graph.SearchText("apple")
+	if !ErrorMatches(err, TEXT_SEARCH_DISABLED) {
+		t.Errorf("SearchText() before EnableTextSearch() produced %v but expected %q", err, TEXT_SEARCH_DISABLED)
+	}
+
+	err = graph.EnableTextSearch()
+	if ErrorMatches(err, NO_TEXT_SEARCH) {
+		t.Skip("SQLite was compiled without FTS5; run the tests with -tags \"json1 sqlite_fts5\" to cover text search")
+	}
+	if err != nil {
+		t.Fatalf("EnableTextSearch() produced an error %q but expected nil", err.Error())
+	}
+
+	// nodes added before and after enabling the index are both found
+	AddNode("3", []byte(jobs), file)
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{"apple", []string{apple}},
+		{"steve", []string{woz, jobs}},
+		{"steve AND jobs", []string{jobs}},
+		{"invoice", []string{}},
+	}
+	for _, test := range tests {
+		actual, err := graph.SearchText(test.query)
+		if len(actual) != len(test.expected) || err != nil {
+			t.Errorf("SearchText(%q) produced %v,%v but expected %v,nil", test.query, actual, err, test.expected)
+			continue
+		}
+		for _, body := range test.expected {
+			if !arrayContains(actual, body) {
+				t.Errorf("SearchText(%q) produced %v but expected it to contain %q", test.query, actual, body)
+			}
+		}
+	}
+
+	// the triggers keep the index current through updates, deletes and vacuums
+	graph.UpdateNode("1", []byte(`{"name":"Apple Inc.","type":"company"}`))
+	graph.RemoveNodes([]string{"2"})
+	graph.Vacuum()
+	for query, count := range map[string]int{"inc": 1, "computer": 0, "wozniak": 0, "jobs": 1} {
+		actual, err := graph.SearchText(query)
+		if len(actual) != count || err != nil {
+			t.Errorf("SearchText(%q) produced %v,%v but expected %d result(s)", query, actual, err, count)
+		}
+	}
+}
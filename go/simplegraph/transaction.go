@@ -0,0 +1,134 @@
+package simplegraph
+
+import (
+	"context"
+	"database/sql"
+)
+
+type Tx struct {
+	ctx   context.Context
+	tx    *sql.Tx
+	graph *Graph
+}
+
+// prepare reuses the graph's cached statement when there is one; statements
+// bound to the transaction are closed along with it on commit or rollback
+func (t *Tx) prepare(ctx context.Context, statement string) (*sql.Stmt, error) {
+	t.graph.mu.Lock()
+	stmt, ok := t.graph.stmts[statement]
+	t.graph.mu.Unlock()
+	if ok {
+		return t.tx.StmtContext(ctx, stmt), nil
+	}
+	return t.tx.PrepareContext(ctx, statement)
+}
+
+// execIn runs a statement ending in an IN clause over args
+func (t *Tx) execIn(statement string, args []interface{}) (int64, error) {
+	stmt, err := t.tx.PrepareContext(t.ctx, withInList(statement, len(args)))
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+	result, err := stmt.ExecContext(t.ctx, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (t *Tx) canonicalJSON() bool {
+	return t.graph.canonicalJSON()
+}
+
+func (t *Tx) checkNode(node []byte) error {
+	return t.graph.checkNode(node)
+}
+
+func (t *Tx) undirectedEdges() bool {
+	return t.graph.undirectedEdges()
+}
+
+func (t *Tx) writable() error {
+	return t.graph.writable()
+}
+
+// retry leaves a statement in a transaction to fail, since a transaction
+// that lost the lock has to be retried as a whole
+func (t *Tx) retry(ctx context.Context, op func() error) error {
+	return op()
+}
+
+// WithTransactionContext runs fn in a transaction which is committed if fn
+// returns nil; when the database stays locked the whole transaction is run
+// again, so fn should only change the database through tx
+func (g *Graph) WithTransactionContext(ctx context.Context, fn func(tx *Tx) error) error {
+	return g.retry(ctx, func() error {
+		return g.runTransaction(ctx, fn)
+	})
+}
+
+func (g *Graph) runTransaction(ctx context.Context, fn func(tx *Tx) error) (err error) {
+	sqlTx, err := g.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			sqlTx.Rollback()
+			panic(p)
+		}
+	}()
+
+	err = fn(&Tx{ctx: ctx, tx: sqlTx, graph: g})
+	if err != nil {
+		sqlTx.Rollback()
+		return err
+	}
+	return sqlTx.Commit()
+}
+
+func (g *Graph) WithTransaction(fn func(tx *Tx) error) error {
+	return g.WithTransactionContext(context.Background(), fn)
+}
+
+func WithTransaction(fn func(tx *Tx) error, database ...string) error {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return err
+	}
+	defer graph.Close()
+	return graph.WithTransaction(fn)
+}
+
+func (t *Tx) AddNode(identifier string, node []byte) (int64, error) {
+	return insertNode(t.ctx, t, identifier, node)
+}
+
+func (t *Tx) ConnectNodesWithProperties(sourceId string, targetId string, properties []byte) (int64, error) {
+	return connectNodes(t.ctx, t, sourceId, targetId, properties)
+}
+
+func (t *Tx) ConnectNodes(sourceId string, targetId string) (int64, error) {
+	return t.ConnectNodesWithProperties(sourceId, targetId, []byte(`{}`))
+}
+
+func (t *Tx) RemoveEdge(sourceId string, targetId string) (int64, error) {
+	return removeEdge(t.ctx, t, sourceId, targetId)
+}
+
+func (t *Tx) RemoveNodes(identifiers []string) (int64, error) {
+	return deleteNodes(t, identifiers)
+}
+
+func (t *Tx) FindNode(identifier string) (string, error) {
+	return findNode(t.ctx, t, identifier)
+}
+
+func (t *Tx) UpdateNode(identifier string, node []byte) (int64, error) {
+	return updateNode(t.ctx, t, identifier, node)
+}
+
+func (t *Tx) UpsertNode(identifier string, node []byte) (int64, error) {
+	return upsertNode(t.ctx, t, identifier, node)
+}
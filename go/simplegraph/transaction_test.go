@@ -0,0 +1,106 @@
+package simplegraph
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestWithTransaction(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	graph, err := NewGraph(file)
+	if err != nil {
+		t.Errorf("NewGraph() produced error %q but expected nil", err.Error())
+	}
+	defer graph.Close()
+
+	err = graph.WithTransaction(func(tx *Tx) error {
+		if _, err := tx.AddNode("1", []byte(apple)); err != nil {
+			return err
+		}
+		if _, err := tx.AddNode("2", []byte(woz)); err != nil {
+			return err
+		}
+		node, err := tx.FindNode("2")
+		if node != woz || err != nil {
+			t.Errorf("FindNode() produced %q,%v inside the transaction but expected %q,nil", node, err, woz)
+		}
+		_, err = tx.ConnectNodesWithProperties("2", "1", []byte(founded))
+		return err
+	})
+	if err != nil {
+		t.Errorf("WithTransaction() produced %v but expected nil", err)
+	}
+
+	edges, err := graph.Connections("2")
+	expected := EdgeData{"2", "1", founded}
+	if len(edges) != 1 || edges[0] != expected || err != nil {
+		t.Errorf("Connections() produced %v,%v but expected [%v],nil", edges, err, expected)
+	}
+
+	err = graph.WithTransaction(func(tx *Tx) error {
+		if _, err := tx.AddNode("3", []byte(jobs)); err != nil {
+			return err
+		}
+		if _, err := tx.RemoveNodes([]string{"2"}); err != nil {
+			return err
+		}
+		_, err := tx.ConnectNodes("3", "7")
+		return err
+	})
+	if !ErrorMatches(err, `target node "7" does not exist`) {
+		t.Errorf("WithTransaction() produced %v but expected the missing target error", err)
+	}
+
+	node, err := graph.FindNode("3")
+	if node != "" || err == nil {
+		t.Errorf("FindNode() produced %q,%v but expected the transaction to be rolled back", node, err)
+	}
+	node, err = graph.FindNode("2")
+	if node != woz || err != nil {
+		t.Errorf("FindNode() produced %q,%v but expected %q,nil", node, err, woz)
+	}
+
+	abort := errors.New("abort")
+	err = WithTransaction(func(tx *Tx) error {
+		tx.UpsertNode("4", []byte(wayne))
+		return abort
+	}, file)
+	if err != abort {
+		t.Errorf("WithTransaction() produced %v but expected %v", err, abort)
+	}
+	node, err = FindNode("4", file)
+	if node != "" || err == nil {
+		t.Errorf("FindNode() produced %q,%v but expected the transaction to be rolled back", node, err)
+	}
+}
+
+func TestTransactionUndirected(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	graph, _ := NewGraphWithOptions([]Option{WithUndirectedEdges()}, file)
+	defer graph.Close()
+	graph.AddNodes([]string{"b", "m"}, [][]byte{[]byte(`{}`), []byte(`{}`)})
+
+	var removed int64
+	err := graph.WithTransaction(func(tx *Tx) error {
+		_, err := tx.ConnectNodes("m", "b")
+		if err != nil {
+			return err
+		}
+		removed, err = tx.RemoveEdge("m", "b")
+		return err
+	})
+	if removed != 1 || err != nil {
+		t.Errorf("RemoveEdge() in a transaction produced %d,%v but expected 1,nil", removed, err)
+	}
+	count, _ := graph.CountEdges()
+	if count != 0 {
+		t.Errorf("RemoveEdge() in a transaction left %d edges but expected none", count)
+	}
+}
@@ -0,0 +1,452 @@
+package simplegraph
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+)
+
+// neighborsOfMany reads the nodes one edge away from each of the ids with
+// one query per chunk of ids rather than one per id; each list keeps the
+// order SearchTargets, or SearchNeighbors if undirected, gives for that id
+func (g *Graph) neighborsOfMany(identifiers []string, undirected bool) (map[string][]string, error) {
+	statements := []string{SearchTargetsOfNodes}
+	if undirected {
+		statements = append(statements, SearchSourcesOfNodes)
+	}
+	neighbors := map[string][]string{}
+	for start := 0; start < len(identifiers); start += MAX_IDS_PER_QUERY {
+		end := start + MAX_IDS_PER_QUERY
+		if end > len(identifiers) {
+			end = len(identifiers)
+		}
+		args := identifierArgs(identifiers[start:end])
+		for _, statement := range statements {
+			err := g.collectNeighbors(withInList(statement, len(args)), args, neighbors)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if undirected {
+		// SearchNeighbors is a UNION, which lists each neighbor once, in order
+		for identifier, found := range neighbors {
+			sort.Strings(found)
+			unique := []string{}
+			for _, neighbor := range found {
+				if len(unique) == 0 || neighbor != unique[len(unique)-1] {
+					unique = append(unique, neighbor)
+				}
+			}
+			neighbors[identifier] = unique
+		}
+	}
+	return neighbors, nil
+}
+
+func (g *Graph) collectNeighbors(statement string, args []interface{}, neighbors map[string][]string) error {
+	rows, err := g.db.Query(statement, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var identifier, neighbor string
+		err = rows.Scan(&identifier, &neighbor)
+		if err != nil {
+			return err
+		}
+		neighbors[identifier] = append(neighbors[identifier], neighbor)
+	}
+	return rows.Err()
+}
+
+// breadthFirst follows outgoing edges only, unless undirected is set or the
+// graph itself is undirected, in which case incoming edges are followed too;
+// the neighbors of a whole level are read at once
+func (g *Graph) breadthFirst(starts []string, maxDepth int, undirected bool, discover func(source string, target string) bool) error {
+	undirected = undirected || g.undirected
+	visited := map[string]bool{}
+	frontier := []string{}
+	for _, start := range starts {
+		if !visited[start] {
+			visited[start] = true
+			frontier = append(frontier, start)
+		}
+	}
+	for depth := 0; len(frontier) > 0 && (maxDepth <= 0 || depth < maxDepth); depth++ {
+		neighbors, err := g.neighborsOfMany(frontier, undirected)
+		if err != nil {
+			return err
+		}
+		next := []string{}
+		for _, identifier := range frontier {
+			for _, target := range neighbors[identifier] {
+				if !visited[target] {
+					visited[target] = true
+					if !discover(identifier, target) {
+						return nil
+					}
+					next = append(next, target)
+				}
+			}
+		}
+		frontier = next
+	}
+	return nil
+}
+
+// targetsStatement finds the nodes one edge away in the direction edges are
+// followed, which on an undirected graph is either
+func (g *Graph) targetsStatement() string {
+	if g.undirected {
+		return SearchNeighbors
+	}
+	return SearchTargets
+}
+
+// neighborArgs binds the id once for each end of an edge targetsStatement
+// or SearchEdges matches on
+func (g *Graph) neighborArgs(identifier string) []interface{} {
+	if g.undirected {
+		return []interface{}{identifier, identifier}
+	}
+	return []interface{}{identifier}
+}
+
+func (g *Graph) TraverseBFS(start string, maxDepth int) ([]string, error) {
+	results := []string{start}
+	err := g.breadthFirst([]string{start}, maxDepth, false, func(source string, target string) bool {
+		results = append(results, target)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func TraverseBFS(start string, maxDepth int, database ...string) ([]string, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return nil, err
+	}
+	defer graph.Close()
+	return graph.TraverseBFS(start, maxDepth)
+}
+
+// ReachableCount is the number of nodes TraverseBFS would reach, not
+// counting start, without keeping the ids; maxDepth is the same limit on hops
+func (g *Graph) ReachableCount(start string, maxDepth int) (int, error) {
+	count := 0
+	err := g.breadthFirst([]string{start}, maxDepth, false, func(source string, target string) bool {
+		count++
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func ReachableCount(start string, maxDepth int, database ...string) (int, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return 0, err
+	}
+	defer graph.Close()
+	return graph.ReachableCount(start, maxDepth)
+}
+
+// TraverseFiltered is TraverseBFS crossing only the outgoing edges whose
+// properties satisfy edgePredicate
+func (g *Graph) TraverseFiltered(start string, edgePredicate func(props string) bool, maxDepth int) ([]string, error) {
+	statement := SearchEdgesInbound
+	if g.undirected {
+		statement = SearchEdges
+	}
+	stmt, err := g.prepare(context.Background(), statement)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []string{start}
+	visited := map[string]bool{start: true}
+	frontier := []string{start}
+	for depth := 0; len(frontier) > 0 && (maxDepth <= 0 || depth < maxDepth); depth++ {
+		next := []string{}
+		for _, identifier := range frontier {
+			edges, err := queryStatementEdges(stmt, g.neighborArgs(identifier)...)
+			if err != nil {
+				return nil, err
+			}
+			for _, edge := range edges {
+				target := edge.Target
+				if target == identifier {
+					target = edge.Source
+				}
+				if !visited[target] && edgePredicate(edge.Label) {
+					visited[target] = true
+					results = append(results, target)
+					next = append(next, target)
+				}
+			}
+		}
+		frontier = next
+	}
+	return results, nil
+}
+
+func TraverseFiltered(start string, edgePredicate func(props string) bool, maxDepth int, database ...string) ([]string, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return nil, err
+	}
+	defer graph.Close()
+	return graph.TraverseFiltered(start, edgePredicate, maxDepth)
+}
+
+func (g *Graph) TraverseDFS(start string, visit func(id string, body string) error) error {
+	targetStmt, err := g.prepare(context.Background(), g.targetsStatement())
+	if err != nil {
+		return err
+	}
+	bodyStmt, err := g.prepare(context.Background(), SearchNodeById)
+	if err != nil {
+		return err
+	}
+
+	visited := map[string]bool{}
+	var walk func(identifier string) error
+	walk = func(identifier string) error {
+		visited[identifier] = true
+		var body string
+		err := bodyStmt.QueryRow(identifier).Scan(&body)
+		if errors.Is(err, sql.ErrNoRows) {
+			return notFoundError{identifier, err}
+		}
+		if err != nil {
+			return err
+		}
+		err = visit(identifier, body)
+		if err != nil {
+			return err
+		}
+		targets, err := queryStatementStrings(targetStmt, g.neighborArgs(identifier)...)
+		if err != nil {
+			return err
+		}
+		for _, target := range targets {
+			if !visited[target] {
+				err = walk(target)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return walk(start)
+}
+
+func TraverseDFS(start string, visit func(id string, body string) error, database ...string) error {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return err
+	}
+	defer graph.Close()
+	return graph.TraverseDFS(start, visit)
+}
+
+func (g *Graph) ShortestPath(from string, to string) ([]string, error) {
+	if from == to {
+		return []string{from}, nil
+	}
+	parents := map[string]string{}
+	found := false
+	err := g.breadthFirst([]string{from}, 0, false, func(source string, target string) bool {
+		parents[target] = source
+		found = target == to
+		return !found
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return []string{}, nil
+	}
+
+	path := []string{to}
+	for path[len(path)-1] != from {
+		path = append(path, parents[path[len(path)-1]])
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path, nil
+}
+
+func ShortestPath(from string, to string, database ...string) ([]string, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return nil, err
+	}
+	defer graph.Close()
+	return graph.ShortestPath(from, to)
+}
+
+func (g *Graph) PathExists(from string, to string, maxDepth int) (bool, error) {
+	if from == to {
+		return true, nil
+	}
+	found := false
+	err := g.breadthFirst([]string{from}, maxDepth, false, func(source string, target string) bool {
+		found = target == to
+		return !found
+	})
+	if err != nil {
+		return false, err
+	}
+	return found, nil
+}
+
+func PathExists(from string, to string, maxDepth int, database ...string) (bool, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return false, err
+	}
+	defer graph.Close()
+	return graph.PathExists(from, to, maxDepth)
+}
+
+func (g *Graph) Subgraph(seeds []string, maxDepth int) ([]string, []EdgeData, error) {
+	reachable := append([]string{}, seeds...)
+	err := g.breadthFirst(seeds, maxDepth, true, func(source string, target string) bool {
+		reachable = append(reachable, target)
+		return true
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	bodies, err := g.FindNodesByIds(reachable)
+	if err != nil {
+		return nil, nil, err
+	}
+	members := []string{}
+	nodes := []string{}
+	included := map[string]bool{}
+	for _, identifier := range reachable {
+		body, found := bodies[identifier]
+		if found && !included[identifier] {
+			included[identifier] = true
+			members = append(members, identifier)
+			nodes = append(nodes, body)
+		}
+	}
+
+	// each edge is read once, from its source, and kept if its target is in
+	// the subgraph as well
+	stmt, err := g.prepare(context.Background(), SearchEdgesInbound)
+	if err != nil {
+		return nil, nil, err
+	}
+	edges := []EdgeData{}
+	for _, identifier := range members {
+		outgoing, err := queryStatementEdges(stmt, identifier)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, edge := range outgoing {
+			if included[edge.Target] {
+				edges = append(edges, edge)
+			}
+		}
+	}
+	return nodes, edges, nil
+}
+
+func Subgraph(seeds []string, maxDepth int, database ...string) ([]string, []EdgeData, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer graph.Close()
+	return graph.Subgraph(seeds, maxDepth)
+}
+
+// AllPaths lists every simple path of at most maxDepth edges from one node
+// to another, following edges in their direction; each node's targets are
+// read once, and parallel edges do not produce duplicate paths
+func (g *Graph) AllPaths(from string, to string, maxDepth int) ([][]string, error) {
+	if maxDepth <= 0 {
+		return nil, errors.New("maxDepth must be greater than zero")
+	}
+	paths := [][]string{}
+	if from == to {
+		return append(paths, []string{from}), nil
+	}
+	stmt, err := g.prepare(context.Background(), g.targetsStatement())
+	if err != nil {
+		return nil, err
+	}
+
+	targets := map[string][]string{}
+	onPath := map[string]bool{}
+	path := []string{}
+	var walk func(identifier string) error
+	walk = func(identifier string) error {
+		path = append(path, identifier)
+		onPath[identifier] = true
+		defer func() {
+			path = path[:len(path)-1]
+			onPath[identifier] = false
+		}()
+
+		if identifier == to {
+			paths = append(paths, append([]string{}, path...))
+			return nil
+		}
+		if len(path) > maxDepth {
+			return nil
+		}
+		next, ok := targets[identifier]
+		if !ok {
+			found, err := queryStatementStrings(stmt, g.neighborArgs(identifier)...)
+			if err != nil {
+				return err
+			}
+			seen := map[string]bool{}
+			for _, target := range found {
+				if !seen[target] {
+					seen[target] = true
+					next = append(next, target)
+				}
+			}
+			targets[identifier] = next
+		}
+		for _, target := range next {
+			if !onPath[target] {
+				if err := walk(target); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	err = walk(from)
+	if err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
+func AllPaths(from string, to string, maxDepth int, database ...string) ([][]string, error) {
+	graph, err := NewGraph(database...)
+	if err != nil {
+		return nil, err
+	}
+	defer graph.Close()
+	return graph.AllPaths(from, to, maxDepth)
+}
@@ -0,0 +1,421 @@
+package simplegraph
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func initializeCycleGraph(t *testing.T, file string) {
+	Initialize(file)
+
+	nodes := [][]byte{}
+	for _, id := range ids {
+		nodes = append(nodes, []byte(fmt.Sprintf("{\"id\":%q}", id)))
+	}
+
+	_, err := AddNodes(ids, nodes, file)
+	if err != nil {
+		t.Errorf("AddNodes() produced an error %q but expected nil", err.Error())
+	}
+
+	_, err = BulkConnectNodes(sources, targets, file)
+	if err != nil {
+		t.Errorf("BulkConnectNodes() produced an error %q but expected nil", err.Error())
+	}
+}
+
+func pathMatches(actual []string, expected []string) bool {
+	if len(actual) != len(expected) {
+		return false
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestTraverseBFS(t *testing.T) {
+	file := "testdb.sqlite3"
+	initializeCycleGraph(t, file)
+	defer os.Remove(file)
+
+	for maxDepth, expected := range map[int][]string{
+		1: {"A", "B", "F"},
+		2: {"A", "B", "F", "C", "G"},
+		0: {"A", "B", "F", "C", "G", "D", "L", "H", "E", "K", "I", "J"},
+	} {
+		visited, err := TraverseBFS("A", maxDepth, file)
+		if err != nil {
+			t.Errorf("TraverseBFS() produced an error %s but expected nil", err.Error())
+		}
+		if !pathMatches(visited, expected) {
+			t.Errorf("TraverseBFS(%d) produced %v but expected %v", maxDepth, visited, expected)
+		}
+	}
+
+	visited, err := TraverseBFS("F", -1, file)
+	if err != nil || !pathMatches(visited, []string{"F"}) {
+		t.Errorf("TraverseBFS() produced %v,%v but expected [F],nil", visited, err)
+	}
+}
+
+func TestReachableCount(t *testing.T) {
+	file := "testdb.sqlite3"
+	initializeCycleGraph(t, file)
+	defer os.Remove(file)
+
+	for _, test := range []struct {
+		start    string
+		maxDepth int
+		expected int
+	}{
+		{"A", 1, 2},
+		{"A", 2, 4},
+		{"A", 0, 11},
+		{"F", 0, 0},
+		{"missing", 0, 0},
+	} {
+		count, err := ReachableCount(test.start, test.maxDepth, file)
+		if count != test.expected || err != nil {
+			t.Errorf("ReachableCount(%q, %d) produced %d,%v but expected %d,nil", test.start, test.maxDepth, count, err, test.expected)
+		}
+	}
+}
+
+func TestTraverseDFS(t *testing.T) {
+	file := "testdb.sqlite3"
+	initializeCycleGraph(t, file)
+	defer os.Remove(file)
+
+	visited := []string{}
+	err := TraverseDFS("A", func(id string, body string) error {
+		expected := fmt.Sprintf("{\"id\":%q}", id)
+		if body != expected {
+			t.Errorf("TraverseDFS() visited %q with body %q but expected %q", id, body, expected)
+		}
+		visited = append(visited, id)
+		return nil
+	}, file)
+	if err != nil {
+		t.Errorf("TraverseDFS() produced an error %s but expected nil", err.Error())
+	}
+	expected := []string{"A", "B", "C", "D", "E", "F", "K", "I", "H", "G", "L", "J"}
+	if !pathMatches(visited, expected) {
+		t.Errorf("TraverseDFS() visited %v but expected %v", visited, expected)
+	}
+
+	stop := errors.New("stop")
+	visited = []string{}
+	err = TraverseDFS("A", func(id string, body string) error {
+		visited = append(visited, id)
+		if len(visited) == 3 {
+			return stop
+		}
+		return nil
+	}, file)
+	if err != stop {
+		t.Errorf("TraverseDFS() produced %v but expected %v", err, stop)
+	}
+	if !pathMatches(visited, expected[:3]) {
+		t.Errorf("TraverseDFS() visited %v but expected %v", visited, expected[:3])
+	}
+
+	err = TraverseDFS("Z", func(id string, body string) error {
+		return nil
+	}, file)
+	if !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("TraverseDFS() from a missing node produced %v but expected %v", err, ErrNodeNotFound)
+	}
+}
+
+func TestShortestPath(t *testing.T) {
+	file := "testdb.sqlite3"
+	initializeCycleGraph(t, file)
+	defer os.Remove(file)
+
+	for _, expected := range [][]string{
+		{"A", "B", "C", "D", "K", "J"},
+		{"A", "B", "C", "D", "E"},
+		{"L", "C", "B", "A"},
+		{"G", "H"},
+		{"G"},
+	} {
+		from, to := expected[0], expected[len(expected)-1]
+		path, err := ShortestPath(from, to, file)
+		if err != nil {
+			t.Errorf("ShortestPath() produced an error %s but expected nil", err.Error())
+		}
+		if !pathMatches(path, expected) {
+			t.Errorf("ShortestPath(%q, %q) produced %v but expected %v", from, to, path, expected)
+		}
+	}
+
+	path, err := ShortestPath("F", "A", file)
+	if path == nil || len(path) != 0 || err != nil {
+		t.Errorf("ShortestPath() produced %v,%v but expected [],nil", path, err)
+	}
+}
+
+func TestPathExists(t *testing.T) {
+	file := "testdb.sqlite3"
+	initializeCycleGraph(t, file)
+	defer os.Remove(file)
+
+	for _, test := range []struct {
+		from     string
+		to       string
+		maxDepth int
+		expected bool
+	}{
+		{"A", "A", 0, true},
+		{"A", "F", 1, true},
+		{"A", "E", 0, true},
+		{"A", "E", 2, false},
+		{"F", "A", 0, false},
+		{"A", "Z", 0, false},
+	} {
+		exists, err := PathExists(test.from, test.to, test.maxDepth, file)
+		if exists != test.expected || err != nil {
+			t.Errorf("PathExists(%q, %q, %d) produced %v,%v but expected %v,nil", test.from, test.to, test.maxDepth, exists, err, test.expected)
+		}
+	}
+}
+
+func TestSubgraph(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	AddNodes([]string{"1", "2", "3", "4", "5"},
+		[][]byte{[]byte(apple), []byte(woz), []byte(jobs), []byte(wayne), []byte(markkula)}, file)
+	ConnectNodesWithProperties("2", "1", []byte(founded), file)
+	ConnectNodesWithProperties("3", "1", []byte(founded), file)
+	ConnectNodesWithProperties("5", "3", []byte(invested), file)
+	ConnectNodes("4", "5", file)
+
+	nodes, edges, err := Subgraph([]string{"2"}, 2, file)
+	if err != nil {
+		t.Fatalf("Subgraph() produced an error %q but expected nil", err.Error())
+	}
+	expectedNodes := []string{woz, apple, jobs}
+	if fmt.Sprint(nodes) != fmt.Sprint(expectedNodes) {
+		t.Errorf("Subgraph() produced nodes %v but expected %v", nodes, expectedNodes)
+	}
+	expectedEdges := []EdgeData{{"2", "1", founded}, {"3", "1", founded}}
+	if fmt.Sprint(edges) != fmt.Sprint(expectedEdges) {
+		t.Errorf("Subgraph() produced edges %v but expected %v", edges, expectedEdges)
+	}
+
+	nodes, edges, err = Subgraph([]string{"4", "99"}, 0, file)
+	if len(nodes) != 5 || len(edges) != 4 || err != nil {
+		t.Errorf("Subgraph() produced %d nodes, %d edges,%v but expected 5,4,nil", len(nodes), len(edges), err)
+	}
+
+	var out bytes.Buffer
+	nodes, edges, _ = Subgraph([]string{"5"}, 1, file)
+	err = WriteDOT(&out, nodes, edges)
+	expected := `digraph {
+  "5" [label="Mike Markkula"];
+  "3" [label="Steve Jobs"];
+  "4" [label="Ronald Wayne"];
+  "5" -> "3" [label="{\"action\":\"invested\",\"equity\":80000,\"debt\":170000}"];
+  "4" -> "5";
+}
+`
+	if out.String() != expected || err != nil {
+		t.Errorf("WriteDOT() produced %q,%v but expected %q,nil", out.String(), err, expected)
+	}
+}
+
+func TestAllPaths(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	identifiers := []string{"a", "b", "c", "d", "e"}
+	nodes := [][]byte{}
+	for range identifiers {
+		nodes = append(nodes, []byte(`{}`))
+	}
+	AddNodes(identifiers, nodes, file)
+	// a diamond from a to d, with a cycle back to a, a parallel edge and a
+	// longer way round through e
+	BulkConnectNodes([]string{"a", "a", "b", "c", "d", "b", "a", "e"},
+		[]string{"b", "c", "d", "d", "a", "d", "e", "c"}, file)
+
+	for _, test := range []struct {
+		from     string
+		to       string
+		maxDepth int
+		expected [][]string
+	}{
+		{"a", "d", 1, [][]string{}},
+		{"a", "d", 2, [][]string{{"a", "b", "d"}, {"a", "c", "d"}}},
+		{"a", "d", 3, [][]string{{"a", "b", "d"}, {"a", "c", "d"}, {"a", "e", "c", "d"}}},
+		{"d", "c", 5, [][]string{{"d", "a", "c"}, {"d", "a", "e", "c"}}},
+		{"a", "a", 3, [][]string{{"a"}}},
+		{"d", "z", 5, [][]string{}},
+	} {
+		paths, err := AllPaths(test.from, test.to, test.maxDepth, file)
+		if len(paths) != len(test.expected) || err != nil {
+			t.Errorf("AllPaths(%q, %q, %d) produced %v,%v but expected %v,nil", test.from, test.to, test.maxDepth, paths, err, test.expected)
+			continue
+		}
+		for _, expected := range test.expected {
+			found := false
+			for _, path := range paths {
+				found = found || pathMatches(path, expected)
+			}
+			if !found {
+				t.Errorf("AllPaths(%q, %q, %d) produced %v but expected it to contain %v", test.from, test.to, test.maxDepth, paths, expected)
+			}
+		}
+	}
+
+	_, err := AllPaths("a", "d", 0, file)
+	if !ErrorMatches(err, "maxDepth must be greater than zero") {
+		t.Errorf("AllPaths() with no depth limit produced %v but expected an error", err)
+	}
+}
+
+func TestTraverseFiltered(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	identifiers := []string{"kid", "mum", "dad", "gran", "friend", "boss"}
+	nodes := [][]byte{}
+	for range identifiers {
+		nodes = append(nodes, []byte(`{}`))
+	}
+	AddNodes(identifiers, nodes, file)
+	parent, friend := `{"rel":"parent"}`, `{"rel":"friend"}`
+	BulkConnectNodesWithProperties(
+		[]string{"kid", "kid", "mum", "kid", "friend", "gran", "mum"},
+		[]string{"mum", "dad", "gran", "friend", "boss", "kid", "boss"},
+		[]string{parent, parent, parent, friend, parent, parent, `{}`}, file)
+
+	isParent := func(props string) bool {
+		var edge struct{ Rel string }
+		return json.Unmarshal([]byte(props), &edge) == nil && edge.Rel == "parent"
+	}
+	for _, test := range []struct {
+		maxDepth int
+		expected []string
+	}{
+		{0, []string{"kid", "mum", "dad", "gran"}},
+		{1, []string{"kid", "mum", "dad"}},
+	} {
+		ancestors, err := TraverseFiltered("kid", isParent, test.maxDepth, file)
+		if !pathMatches(ancestors, test.expected) || err != nil {
+			t.Errorf("TraverseFiltered(%d) produced %v,%v but expected %v,nil", test.maxDepth, ancestors, err, test.expected)
+		}
+	}
+
+	none, err := TraverseFiltered("kid", func(props string) bool { return false }, 0, file)
+	if !pathMatches(none, []string{"kid"}) || err != nil {
+		t.Errorf("TraverseFiltered() produced %v,%v but expected only the start", none, err)
+	}
+}
+
+// initializeWideGraph links a root to every other node, then node i to node
+// i+1 for the rest of the first half
+func initializeWideGraph(t testing.TB, file string, n int) []string {
+	Initialize(file)
+	identifiers, nodes := makeBenchmarkNodes(n)
+	_, err := AddNodes(identifiers, nodes, file)
+	if err != nil {
+		t.Fatalf("AddNodes() produced an error %q but expected nil", err.Error())
+	}
+	sources, targets := []string{}, []string{}
+	for i := 1; i < n; i++ {
+		sources, targets = append(sources, "0"), append(targets, identifiers[i])
+		if i < n/2 {
+			sources, targets = append(sources, identifiers[i]), append(targets, identifiers[i+1])
+		}
+	}
+	_, err = BulkConnectNodes(sources, targets, file)
+	if err != nil {
+		t.Fatalf("BulkConnectNodes() produced an error %q but expected nil", err.Error())
+	}
+	return identifiers
+}
+
+func TestNeighborsOfMany(t *testing.T) {
+	file := "testdb.sqlite3"
+	n := MAX_IDS_PER_QUERY*2 + 10
+	identifiers := initializeWideGraph(t, file, n)
+	defer os.Remove(file)
+	graph, _ := NewGraph(file)
+	defer graph.Close()
+
+	stmt, _ := graph.prepare(context.Background(), SearchTargets)
+	undirectedStmt, _ := graph.prepare(context.Background(), SearchNeighbors)
+	outgoing, err := graph.neighborsOfMany(identifiers, false)
+	if err != nil {
+		t.Fatalf("neighborsOfMany() produced an error %q but expected nil", err.Error())
+	}
+	both, err := graph.neighborsOfMany(identifiers, true)
+	if err != nil {
+		t.Fatalf("neighborsOfMany() produced an error %q but expected nil", err.Error())
+	}
+	for _, identifier := range identifiers {
+		expected, _ := queryStatementStrings(stmt, identifier)
+		if !pathMatches(outgoing[identifier], expected) {
+			t.Errorf("neighborsOfMany() listed %v for %q but expected %v", outgoing[identifier], identifier, expected)
+		}
+		expected, _ = queryStatementStrings(undirectedStmt, identifier, identifier)
+		if !pathMatches(both[identifier], expected) {
+			t.Errorf("neighborsOfMany(undirected) listed %v for %q but expected %v", both[identifier], identifier, expected)
+		}
+	}
+}
+
+func BenchmarkNeighborsOneAtATime(b *testing.B) {
+	file := "testdb.sqlite3"
+	identifiers := initializeWideGraph(b, file, 2000)
+	defer os.Remove(file)
+	graph, _ := NewGraph(file)
+	defer graph.Close()
+	stmt, _ := graph.prepare(context.Background(), SearchTargets)
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for _, identifier := range identifiers {
+			queryStatementStrings(stmt, identifier)
+		}
+	}
+}
+
+func BenchmarkNeighborsOfMany(b *testing.B) {
+	file := "testdb.sqlite3"
+	identifiers := initializeWideGraph(b, file, 2000)
+	defer os.Remove(file)
+	graph, _ := NewGraph(file)
+	defer graph.Close()
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		graph.neighborsOfMany(identifiers, false)
+	}
+}
+
+func BenchmarkTraverseBFSWide(b *testing.B) {
+	file := "testdb.sqlite3"
+	initializeWideGraph(b, file, 2000)
+	defer os.Remove(file)
+	graph, _ := NewGraph(file)
+	defer graph.Close()
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		graph.TraverseBFS("0", 0)
+	}
+}
@@ -0,0 +1,47 @@
+package simplegraph
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// AddTypedNode stores node as its encoding/json form, which must be a JSON
+// object; as with AddNode, the id is added to the body if it has none
+func AddTypedNode[T any](identifier string, node T, database ...string) (int64, error) {
+	body, err := json.Marshal(node)
+	if err != nil {
+		return 0, err
+	}
+	return AddNode(identifier, body, database...)
+}
+
+// FindTypedNode decodes the body of the node into a T, or returns the zero
+// value and an ErrNodeNotFound error if there is no node with that id
+func FindTypedNode[T any](identifier string, database ...string) (T, error) {
+	var node T
+	body, err := FindNode(identifier, database...)
+	if err != nil {
+		return node, err
+	}
+	err = json.Unmarshal([]byte(body), &node)
+	return node, err
+}
+
+// FindTypedNodes decodes each body FindNodesByProperty finds into a T; a
+// body that does not decode stops the search with an error quoting it
+func FindTypedNodes[T any](key string, value string, database ...string) ([]T, error) {
+	bodies, err := FindNodesByProperty(key, value, database...)
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]T, 0, len(bodies))
+	for _, body := range bodies {
+		var node T
+		err = json.Unmarshal([]byte(body), &node)
+		if err != nil {
+			return nil, fmt.Errorf("decoding node %s: %w", body, err)
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
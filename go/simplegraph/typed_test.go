@@ -0,0 +1,75 @@
+package simplegraph
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+type company struct {
+	Id      string   `json:"id,omitempty"`
+	Name    string   `json:"name"`
+	Type    []string `json:"type"`
+	Founded string   `json:"founded"`
+}
+
+func TestTypedNodes(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	expected := company{Id: "1", Name: "Apple Computer Company", Type: []string{"company", "start-up"}, Founded: "April 1, 1976"}
+	count, err := AddTypedNode("1", company{Name: expected.Name, Type: expected.Type, Founded: expected.Founded}, file)
+	if count != 1 || err != nil {
+		t.Errorf("AddTypedNode() inserted %d,%v but expected 1,nil", count, err)
+	}
+
+	found, err := FindTypedNode[company]("1", file)
+	if found.Id != expected.Id || found.Name != expected.Name || len(found.Type) != 2 || found.Founded != expected.Founded || err != nil {
+		t.Errorf("FindTypedNode() produced %+v,%v but expected %+v,nil", found, err, expected)
+	}
+
+	// the typed body is stored as ordinary JSON, so the untyped calls see it too
+	node, err := FindNode("1", file)
+	if node != `{"founded":"April 1, 1976","id":"1","name":"Apple Computer Company","type":["company","start-up"]}` || err != nil {
+		t.Errorf("FindNode() produced %q,%v", node, err)
+	}
+
+	missing, err := FindTypedNode[company]("2", file)
+	if missing.Name != "" || !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("FindTypedNode() produced %+v,%v but expected the zero value,%v", missing, err, ErrNodeNotFound)
+	}
+
+	_, err = AddTypedNode("2", []string{"not", "an", "object"}, file)
+	if err == nil {
+		t.Errorf("AddTypedNode() produced nil but expected an error for a non-object body")
+	}
+}
+
+func TestFindTypedNodes(t *testing.T) {
+	file := "testdb.sqlite3"
+	Initialize(file)
+	defer os.Remove(file)
+
+	AddNodes([]string{"1", "5"}, [][]byte{[]byte(apple), []byte(`{"name":"Apple Inc.","founded":"January 3, 1977"}`)}, file)
+	AddNode("6", []byte(`{"name":"Apple Records","founded":1968}`), file)
+
+	found, err := FindTypedNodes[company]("founded", "January 3, 1977", file)
+	if len(found) != 1 || found[0].Id != "5" || found[0].Name != "Apple Inc." || err != nil {
+		t.Errorf("FindTypedNodes() produced %+v,%v but expected the one company,nil", found, err)
+	}
+
+	none, err := FindTypedNodes[company]("name", "Pear", file)
+	if none == nil || len(none) != 0 || err != nil {
+		t.Errorf("FindTypedNodes() produced %+v,%v but expected [],nil", none, err)
+	}
+
+	_, err = FindTypedNodes[company]("name", "Apple Records", file)
+	expected := `decoding node {"founded":1968,"id":"6","name":"Apple Records"}: `
+	var typeErr *json.UnmarshalTypeError
+	if err == nil || !strings.HasPrefix(err.Error(), expected) || !errors.As(err, &typeErr) {
+		t.Errorf("FindTypedNodes() produced %v but expected it to start %q and wrap the decoding error", err, expected)
+	}
+}
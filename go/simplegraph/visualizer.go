@@ -36,25 +36,29 @@ func Visualize(path []string, database ...string) string {
 		gv.Close()
 	}()
 
+	db, err := NewGraph(database...)
+	evaluate(err)
+	defer db.Close()
+
 	nodes := make(map[string]*cgraph.Node)
 	plotted := NewEdgeSet()
 	for _, identifier := range path {
 		var node *cgraph.Node
-		body, err := FindNode(identifier, database...)
+		body, err := db.FindNode(identifier)
 		evaluate(err)
 		node, err = graph.CreateNode(identifier)
 		evaluate(err)
 		node.SetLabel(body)
 		nodes[identifier] = node
 
-		edges, err := Connections(identifier, database...)
+		edges, err := db.Connections(identifier)
 		evaluate(err)
 		for _, edge := range edges {
 			if !plotted.Contains(edge) {
 				plotted.Add(edge)
 				_, exists := nodes[edge.Target]
 				if !exists {
-					body, err = FindNode(edge.Target, database...)
+					body, err = db.FindNode(edge.Target)
 					evaluate(err)
 					target, err := graph.CreateNode(edge.Target)
 					evaluate(err)
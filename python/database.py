@@ -75,7 +75,7 @@ def _upsert_node(cursor, identifier, data):
         # merge the current and new data and update
         updated_data = {**current_data, **data}
         cursor.execute(read_sql(
-            'update-node.sql'), (json.dumps(_set_id(identifier, updated_data)), identifier,))
+            'update-node-by-id.sql'), (json.dumps(_set_id(identifier, updated_data)), identifier,))
 
 
 def upsert_node(identifier, data):
@@ -0,0 +1 @@
+PRAGMA wal_checkpoint(TRUNCATE)
@@ -0,0 +1 @@
+SELECT count(*) FROM edges WHERE target = ?
@@ -0,0 +1,3 @@
+SELECT value, count(*) FROM (SELECT json_extract(body, ?) AS value FROM nodes)
+WHERE value IS NOT NULL
+GROUP BY value
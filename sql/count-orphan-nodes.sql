@@ -0,0 +1,3 @@
+SELECT count(*) FROM nodes
+WHERE NOT EXISTS (SELECT 1 FROM edges WHERE source = nodes.id)
+  AND NOT EXISTS (SELECT 1 FROM edges WHERE target = nodes.id)
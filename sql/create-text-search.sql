@@ -0,0 +1,14 @@
+CREATE VIRTUAL TABLE IF NOT EXISTS nodes_fts USING fts5(body, content = 'nodes', content_rowid = 'rowid');
+
+CREATE TRIGGER IF NOT EXISTS nodes_fts_insert AFTER INSERT ON nodes BEGIN
+  INSERT INTO nodes_fts(rowid, body) VALUES (new.rowid, new.body);
+END;
+
+CREATE TRIGGER IF NOT EXISTS nodes_fts_delete AFTER DELETE ON nodes BEGIN
+  INSERT INTO nodes_fts(nodes_fts, rowid, body) VALUES ('delete', old.rowid, old.body);
+END;
+
+CREATE TRIGGER IF NOT EXISTS nodes_fts_update AFTER UPDATE ON nodes BEGIN
+  INSERT INTO nodes_fts(nodes_fts, rowid, body) VALUES ('delete', old.rowid, old.body);
+  INSERT INTO nodes_fts(rowid, body) VALUES (new.rowid, new.body);
+END;
@@ -0,0 +1 @@
+PRAGMA defer_foreign_keys = ON
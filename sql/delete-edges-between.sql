@@ -0,0 +1 @@
+DELETE FROM edges WHERE source = ? AND target = ?
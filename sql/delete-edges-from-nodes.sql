@@ -0,0 +1 @@
+DELETE FROM edges WHERE source IN 
\ No newline at end of file
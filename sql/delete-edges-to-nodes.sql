@@ -0,0 +1 @@
+DELETE FROM edges WHERE target IN 
\ No newline at end of file
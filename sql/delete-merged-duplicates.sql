@@ -0,0 +1,9 @@
+WITH moved(edge, other) AS (
+  SELECT rowid, CASE WHEN source = ?1 AND target = ?1 THEN ?2 WHEN source = ?1 THEN target ELSE source END
+  FROM edges WHERE source = ?1 OR target = ?1
+)
+DELETE FROM edges WHERE rowid IN (
+  SELECT edge FROM moved WHERE EXISTS (
+    SELECT 1 FROM edges WHERE (source = ?2 AND target = moved.other) OR (source = moved.other AND target = ?2)
+  )
+)
@@ -0,0 +1 @@
+DELETE FROM nodes WHERE id IN 
\ No newline at end of file
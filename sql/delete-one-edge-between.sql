@@ -0,0 +1,3 @@
+DELETE FROM edges WHERE rowid = (
+  SELECT rowid FROM edges WHERE source = ? AND target = ? AND properties = json(?) LIMIT 1
+)
@@ -0,0 +1 @@
+EXPLAIN QUERY PLAN 
\ No newline at end of file
@@ -0,0 +1 @@
+PRAGMA foreign_keys = OFF
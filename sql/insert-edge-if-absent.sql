@@ -0,0 +1,2 @@
+INSERT INTO edges SELECT ?, ?, json(?)
+WHERE NOT EXISTS (SELECT 1 FROM edges WHERE source = ? AND target = ?)
@@ -0,0 +1,2 @@
+INSERT INTO nodes VALUES(json(?))
+ON CONFLICT(id) DO NOTHING
@@ -0,0 +1,2 @@
+INSERT INTO nodes VALUES(json_set(json(?), '$.id', ?))
+ON CONFLICT(id) DO UPDATE SET body = excluded.body
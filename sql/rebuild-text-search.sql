@@ -0,0 +1 @@
+INSERT INTO nodes_fts(nodes_fts) VALUES ('rebuild')
@@ -0,0 +1 @@
+SELECT source, target, properties FROM edges ORDER BY rowid LIMIT ? OFFSET ?
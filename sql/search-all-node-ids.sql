@@ -0,0 +1 @@
+SELECT id FROM nodes ORDER BY rowid
@@ -0,0 +1 @@
+SELECT body FROM nodes ORDER BY rowid LIMIT ? OFFSET ?
@@ -0,0 +1,3 @@
+SELECT DISTINCT value FROM (SELECT json_extract(body, ?) AS value FROM nodes)
+WHERE value IS NOT NULL
+ORDER BY value
@@ -0,0 +1 @@
+SELECT properties FROM edges WHERE source = ? AND target = ?
@@ -0,0 +1,3 @@
+SELECT source, target, properties FROM edges
+WHERE (source = ? AND target = ?) OR (source = ? AND target = ?)
+ORDER BY rowid
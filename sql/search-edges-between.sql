@@ -0,0 +1 @@
+SELECT source, target, properties FROM edges WHERE source = ? AND target = ? ORDER BY rowid
@@ -0,0 +1 @@
+SELECT source, target, properties FROM edges WHERE source IN 
\ No newline at end of file
@@ -0,0 +1,3 @@
+SELECT coalesce(max(degree), 0) FROM (
+  SELECT (SELECT count(*) FROM edges WHERE source = nodes.id) + (SELECT count(*) FROM edges WHERE target = nodes.id) AS degree FROM nodes
+)
@@ -0,0 +1,3 @@
+SELECT target FROM edges WHERE source = ?
+UNION
+SELECT source FROM edges WHERE target = ?
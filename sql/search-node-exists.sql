@@ -0,0 +1 @@
+SELECT 1 FROM nodes WHERE id = ? LIMIT 1
@@ -0,0 +1 @@
+SELECT body FROM nodes WHERE json_extract(body, ?) = ?
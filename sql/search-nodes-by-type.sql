@@ -0,0 +1,2 @@
+SELECT body FROM nodes
+WHERE EXISTS (SELECT 1 FROM json_each(nodes.body, '$.type') WHERE json_each.value = ?)
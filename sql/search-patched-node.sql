@@ -0,0 +1 @@
+SELECT json_patch(body, json(?)) FROM nodes WHERE id = ?
@@ -0,0 +1 @@
+SELECT id, json_patch(body, json(?)) FROM nodes WHERE 
\ No newline at end of file
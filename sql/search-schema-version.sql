@@ -0,0 +1 @@
+PRAGMA user_version
\ No newline at end of file
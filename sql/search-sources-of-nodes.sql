@@ -0,0 +1 @@
+SELECT target, source FROM edges WHERE target IN 
\ No newline at end of file
@@ -0,0 +1 @@
+SELECT source, target FROM edges WHERE source IN 
\ No newline at end of file
@@ -0,0 +1 @@
+SELECT target FROM edges WHERE source = ?
@@ -0,0 +1 @@
+UPDATE edges SET source = ?, target = ? WHERE source = ? AND target = ?
@@ -0,0 +1 @@
+UPDATE edges SET source = ? WHERE source = ? AND target != ?
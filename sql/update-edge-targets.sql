@@ -0,0 +1 @@
+UPDATE edges SET target = ? WHERE target = ? AND source != ?
@@ -0,0 +1 @@
+UPDATE edges SET properties = json(?) WHERE source = ? AND target = ?
@@ -0,0 +1,2 @@
+UPDATE edges SET source = target, target = source
+WHERE (source = ? OR target = ?) AND source > target
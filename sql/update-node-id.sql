@@ -0,0 +1 @@
+UPDATE nodes SET body = json_set(body, '$.id', ?) WHERE id = ?
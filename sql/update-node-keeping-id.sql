@@ -0,0 +1 @@
+UPDATE nodes SET body = json_set(json(?), '$.id', ?) WHERE id = ?
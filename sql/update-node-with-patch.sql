@@ -0,0 +1 @@
+UPDATE nodes SET body = json_set(json_patch(body, json(?)), '$.id', ?) WHERE id = ?
@@ -0,0 +1 @@
+UPDATE nodes SET body = json_set(json_patch(body, json(?)), '$.id', id) WHERE 
\ No newline at end of file
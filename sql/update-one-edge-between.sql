@@ -0,0 +1,3 @@
+UPDATE edges SET properties = json(?) WHERE rowid = (
+  SELECT rowid FROM edges WHERE source = ? AND target = ? AND properties = json(?) LIMIT 1
+)